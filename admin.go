@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"myproject/store"
+)
+
+const adminUsage = `usage: myproject [-config file] admin <command>
+
+commands:
+  grant <username>    allow the user to moderate comments
+  revoke <username>   remove the user's moderator rights
+  claim <username>    print a one-time token that lets the owner of a
+                      passwordless account set its password at POST /claim`
+
+// requireAdmin rejects requests that are not from an admin.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := currentUser(r); !user.IsAdmin {
+			http.Error(w, "Admin rights required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// purgeComment permanently removes a comment together with its replies,
+// likes and connections. Unlike deleteComment nothing is left behind.
+func purgeComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	// Look the comment up first; afterwards its page is unknown.
+	c, err := repo.Comments.Get(r.Context(), id, 0)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	purged, err := repo.Comments.Purge(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error purging comment", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	hub.publish(c.URL, eventCommentPurged, map[string]int{"id": id})
+	fireWebhooks(r.Context(), webhookCommentDeleted, c.URL, map[string]any{"id": id, "purged": true})
+
+	user, _ := currentUser(r)
+	log.Printf("Admin %s purged comment %d (%d comments removed)", user.Username, id, purged)
+	writeJSON(w, map[string]int{"purged": purged})
+}
+
+// runAdmin implements the "admin" subcommand and returns the process exit
+// code.
+func runAdmin(args []string) int {
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke" && args[0] != "claim") {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		return 2
+	}
+	ctx := context.Background()
+
+	pending, err := store.Pending(ctx, db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "database has %d pending migrations; run `migrate up`\n", len(pending))
+		return 1
+	}
+
+	repo = store.NewSQLite(db)
+	users := repo.Users
+	user, err := users.ByUsername(ctx, args[1])
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "no user named %q\n", args[1])
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if args[0] == "claim" {
+		token, expiresAt, err := issueClaimToken(ctx, user)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("claim token for %s, valid until %s:\n%s\n",
+			user.Username, expiresAt.UTC().Format(time.RFC3339), token)
+		return 0
+	}
+
+	grant := args[0] == "grant"
+	if err := users.SetAdmin(ctx, user.ID, grant); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if grant {
+		fmt.Printf("%s is now an admin\n", user.Username)
+	} else {
+		fmt.Printf("%s is no longer an admin\n", user.Username)
+	}
+	return 0
+}
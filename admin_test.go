@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"myproject/store"
+)
+
+func TestPurgeComment(t *testing.T) {
+	setupTestRepo(t)
+	ctx := context.Background()
+	alice, aliceToken := createTestUser(t, "alice", "")
+	admin, adminToken := createTestUser(t, "admin", "")
+	if err := repo.Users.SetAdmin(ctx, admin.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	id := createTestComment(t, alice)
+	if _, err := repo.Comments.Create(ctx, &store.Comment{
+		URL: "https://example.com/", UserID: admin.ID, ParentID: &id, Comment: "reply",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	events, _, _ := hub.subscribe("https://example.com/", "")
+	defer hub.unsubscribe("https://example.com/", events)
+
+	target := "/admin/comments/" + strconv.Itoa(id)
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"anonymous", "", http.StatusUnauthorized, ""},
+		{"author", aliceToken, http.StatusForbidden, "Admin rights required"},
+		{"admin", adminToken, http.StatusOK, `{"purged":2}`},
+		{"already purged", adminToken, http.StatusNotFound, "Comment not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, "DELETE", target, tt.token, "")
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	if e := receive(t, events); e.typ != eventCommentPurged || string(e.data) != `{"id":`+strconv.Itoa(id)+`}` {
+		t.Errorf("stream event = %+v, want the purge", e)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"myproject/store"
+)
+
+type ctxKey int
+
+const userCtxKey ctxKey = iota
+
+const minPasswordLength = 8
+
+// claimTokenTTL is how long a claim token from `admin claim` stays valid.
+const claimTokenTTL = 7 * 24 * time.Hour
+
+// currentUser returns the user authenticated by the request's bearer token.
+func currentUser(r *http.Request) (store.User, bool) {
+	u, ok := r.Context().Value(userCtxKey).(store.User)
+	return u, ok
+}
+
+// viewerID is the authenticated user's ID, or 0 for anonymous requests.
+func viewerID(r *http.Request) int {
+	u, _ := currentUser(r)
+	return u.ID
+}
+
+// authenticate resolves an "Authorization: Bearer <token>" header into the
+// request context. Requests without the header pass through anonymously;
+// requests with an invalid or expired token are rejected.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := repo.Sessions.UserID(r.Context(), hashToken(token))
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		user, err := repo.Users.ByID(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			log.Println(err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userCtxKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireUser rejects anonymous requests with 401.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentUser(r); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// hasKarma checks the caller's karma against a threshold from cfg.Karma and
+// rejects the request with 403 if it falls short.
+func hasKarma(w http.ResponseWriter, r *http.Request, min *float64, action string) bool {
+	user, _ := currentUser(r)
+	if min == nil || user.Karma >= *min {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("You need %g karma to %s", *min, action), http.StatusForbidden)
+	return false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs and headers.
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// createSession stores a new session for userID and returns its token.
+func createSession(ctx context.Context, userID int) (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(cfg.Auth.SessionTTL)
+	if err := repo.Sessions.Create(ctx, hashToken(token), userID, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// issueSession creates a session for user and writes the token response.
+func issueSession(w http.ResponseWriter, r *http.Request, user store.User) {
+	token, expiresAt, err := createSession(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"user_id":    user.ID,
+		"public_id":  user.PublicID,
+		"username":   user.Username,
+		"token":      token,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// register creates an account with a password and logs it in. Every
+// existing username is refused, including accounts that have no password
+// yet; their owners get a claim token from an admin and use claimAccount.
+func register(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || len(req.Username) > 64 {
+		http.Error(w, "Username must be 1-64 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "Password too short", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	user := store.User{Username: req.Username, PasswordHash: string(hash)}
+	err = repo.Users.Create(r.Context(), &user)
+	if errors.Is(err, store.ErrConflict) {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	issueSession(w, r, user)
+}
+
+// setPassword sets the logged-in user's password. Accounts that already
+// have one must confirm it; accounts created by an OIDC provider can add
+// one, the session being proof of ownership.
+func setPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "Password too short", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if user.PasswordHash != "" &&
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if err := repo.Users.SetPassword(r.Context(), user.ID, string(hash)); err != nil {
+		http.Error(w, "Error setting password", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "Password set"})
+}
+
+// issueClaimToken returns a one-time token that sets the password of user,
+// which must not have one. Accounts from the old passwordless login cannot
+// log in any other way; an admin hands the token over once the owner has
+// proven who they are.
+func issueClaimToken(ctx context.Context, user store.User) (string, time.Time, error) {
+	if user.PasswordHash != "" {
+		return "", time.Time{}, fmt.Errorf("%s already has a password", user.Username)
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(claimTokenTTL)
+	if err := repo.Users.CreateClaim(ctx, hashToken(token), user.ID, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// claimAccount redeems a claim token from issueClaimToken: it sets the
+// account's password and logs it in.
+func claimAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "Password too short", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	user, err := repo.Users.Claim(r.Context(), hashToken(req.Token), string(hash))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Invalid or expired claim token", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		http.Error(w, "Account already has a password", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error claiming account", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	issueSession(w, r, user)
+}
+
+// login exchanges a username and password for a session token.
+func login(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Received login request for: %s", req.Username)
+
+	user, err := repo.Users.ByUsername(r.Context(), strings.TrimSpace(req.Username))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if user.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	issueSession(w, r, user)
+}
+
+// logout ends the session whose token authenticated the request.
+func logout(w http.ResponseWriter, r *http.Request) {
+	token, _ := bearerToken(r)
+	if err := repo.Sessions.Delete(r.Context(), hashToken(token)); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "Logged out"})
+}
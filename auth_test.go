@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+)
+
+// setupTestRepo points the package globals at a fresh, migrated database.
+func setupTestRepo(t *testing.T) {
+	t.Helper()
+	cfg = defaultConfig()
+	setupURLs()
+
+	var err error
+	db, err = sql.Open(store.DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := store.MigrateUp(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	repo = store.NewSQLite(db)
+}
+
+// testRouter serves the routes under test the way main does.
+func testRouter() http.Handler {
+	r := mux.NewRouter()
+	r.Use(authenticate)
+	r.HandleFunc("/register", register).Methods("POST")
+	r.HandleFunc("/login", login).Methods("POST")
+	r.HandleFunc("/password", requireUser(setPassword)).Methods("PUT")
+	r.HandleFunc("/claim", claimAccount).Methods("POST")
+	r.HandleFunc("/comments", requireUser(postComment)).Methods("POST")
+	r.HandleFunc("/comments/{id}/vote", requireUser(putVote)).Methods("PUT")
+	r.HandleFunc("/comments/{id}/reactions/{kind}", requireUser(putReaction)).Methods("PUT", "DELETE")
+	r.HandleFunc("/comment_like", requireUser(commentLike)).Methods("POST")
+	r.HandleFunc("/connect_users", requireUser(connectUsers)).Methods("POST")
+	r.HandleFunc("/disconnect_users", requireUser(disconnectUsers)).Methods("DELETE")
+	r.HandleFunc("/admin/comments/{id}", requireAdmin(purgeComment)).Methods("DELETE")
+	r.HandleFunc("/auth/oidc/{provider}/start", oidcStart).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", oidcCallback).Methods("GET")
+	return r
+}
+
+func serve(t *testing.T, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	testRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+// createTestUser adds a user, with a password hash unless hash is empty,
+// and returns it with a session token.
+func createTestUser(t *testing.T, username, hash string) (store.User, string) {
+	t.Helper()
+	ctx := context.Background()
+	user := store.User{Username: username, PasswordHash: hash}
+	if err := repo.Users.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := createSession(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+func TestRegister(t *testing.T) {
+	setupTestRepo(t)
+	createTestUser(t, "legacy", "")
+	createTestUser(t, "taken", "$2a$10$invalidbutpresent")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"new user", `{"username": "alice", "password": "correct horse"}`, http.StatusOK},
+		{"passwordless account", `{"username": "legacy", "password": "correct horse"}`, http.StatusConflict},
+		{"account with password", `{"username": "taken", "password": "correct horse"}`, http.StatusConflict},
+		{"name is trimmed", `{"username": " legacy ", "password": "correct horse"}`, http.StatusConflict},
+		{"short password", `{"username": "bob", "password": "short"}`, http.StatusBadRequest},
+		{"empty username", `{"username": " ", "password": "correct horse"}`, http.StatusBadRequest},
+		{"invalid JSON", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, "POST", "/register", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	legacy, err := repo.Users.ByUsername(context.Background(), "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.PasswordHash != "" {
+		t.Error("register set a password on an existing account")
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	setupTestRepo(t)
+	// Accounts created through OIDC have a session but no password.
+	_, oidcToken := createTestUser(t, "oidc", "")
+
+	rec := serve(t, "POST", "/register", "", `{"username": "alice", "password": "first password"}`)
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"anonymous", "", `{"password": "new password"}`, http.StatusUnauthorized},
+		{"passwordless account", oidcToken, `{"password": "oidc password"}`, http.StatusOK},
+		{"wrong current password", session.Token, `{"current_password": "nope", "password": "new password"}`, http.StatusForbidden},
+		{"missing current password", session.Token, `{"password": "new password"}`, http.StatusForbidden},
+		{"too short", session.Token, `{"current_password": "first password", "password": "short"}`, http.StatusBadRequest},
+		{"change", session.Token, `{"current_password": "first password", "password": "new password"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, "PUT", "/password", tt.token, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	logins := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"username": "oidc", "password": "oidc password"}`, http.StatusOK},
+		{`{"username": "alice", "password": "new password"}`, http.StatusOK},
+		{`{"username": "alice", "password": "first password"}`, http.StatusUnauthorized},
+	}
+	for _, l := range logins {
+		if rec := serve(t, "POST", "/login", "", l.body); rec.Code != l.wantStatus {
+			t.Errorf("login %s: status = %d, want %d", l.body, rec.Code, l.wantStatus)
+		}
+	}
+}
+
+func TestClaimAccount(t *testing.T) {
+	setupTestRepo(t)
+	ctx := context.Background()
+	legacy, _ := createTestUser(t, "legacy", "")
+	late, _ := createTestUser(t, "late", "")
+	hasPassword, _ := createTestUser(t, "taken", "$2a$10$invalidbutpresent")
+
+	if _, _, err := issueClaimToken(ctx, hasPassword); err == nil {
+		t.Error("issueClaimToken succeeded for an account with a password")
+	}
+	issue := func(user store.User) string {
+		t.Helper()
+		token, expiresAt, err := issueClaimToken(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Until(expiresAt) <= 0 {
+			t.Fatalf("claim token expires at %v", expiresAt)
+		}
+		return token
+	}
+	token, other := issue(legacy), issue(legacy)
+	lateToken := issue(late)
+	if err := repo.Users.CreateClaim(ctx, hashToken("expired"), legacy.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// The account got a password some other way after the token was issued.
+	if err := repo.Users.SetPassword(ctx, late.ID, "$2a$10$invalidbutpresent"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := func(token, password string) string {
+		return fmt.Sprintf(`{"token": %q, "password": %q}`, token, password)
+	}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown token", body("nope", "legacy password"), http.StatusUnauthorized},
+		{"expired token", body("expired", "legacy password"), http.StatusUnauthorized},
+		{"short password", body(token, "short"), http.StatusBadRequest},
+		{"invalid JSON", `{`, http.StatusBadRequest},
+		{"claim", body(token, "legacy password"), http.StatusOK},
+		{"token used twice", body(token, "other password"), http.StatusUnauthorized},
+		{"other token of the account", body(other, "other password"), http.StatusUnauthorized},
+		{"account has a password by now", body(lateToken, "late password"), http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, "POST", "/claim", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code == http.StatusOK {
+				var session struct {
+					UserID int    `json:"user_id"`
+					Token  string `json:"token"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&session); err != nil || session.UserID != legacy.ID || session.Token == "" {
+					t.Errorf("session = %+v, %v; want one for %d", session, err, legacy.ID)
+				}
+			}
+		})
+	}
+
+	if rec := serve(t, "POST", "/login", "", `{"username": "legacy", "password": "legacy password"}`); rec.Code != http.StatusOK {
+		t.Errorf("login with the claimed password: status = %d", rec.Code)
+	}
+}
+
+func TestKarmaThresholds(t *testing.T) {
+	setupTestRepo(t)
+	ctx := context.Background()
+	minToComment, minToDownvote := 1.0, 10.0
+	cfg.Karma.MinToComment = &minToComment
+	cfg.Karma.MinToDownvote = &minToDownvote
+
+	author, authorToken := createTestUser(t, "author", "")
+	_, newToken := createTestUser(t, "newcomer", "")
+	trusted, trustedToken := createTestUser(t, "trusted", "")
+	// Stored karma is scaled to the karma epoch; see store.KarmaHalfLife.
+	if _, err := db.Exec(`UPDATE users SET karma = ? WHERE id IN (?, ?)`,
+		20/store.KarmaAt(1, time.Now()), author.ID, trusted.ID); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := repo.Comments.Create(ctx, &store.Comment{
+		URL: "https://example.com/", UserID: author.ID, Username: "author", Comment: "hi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote := fmt.Sprintf("/comments/%d/vote", comment)
+
+	tests := []struct {
+		name, method, target, token, body string
+		wantStatus                        int
+	}{
+		{"comment without karma", "POST", "/comments", newToken, `{"url": "https://example.com/", "comment": "hi"}`, http.StatusForbidden},
+		{"comment with karma", "POST", "/comments", authorToken, `{"url": "https://example.com/", "comment": "hi"}`, http.StatusOK},
+		{"upvote without karma", "PUT", vote, newToken, `{"vote": "up"}`, http.StatusOK},
+		{"downvote without karma", "PUT", vote, newToken, `{"vote": "down"}`, http.StatusForbidden},
+		{"clear without karma", "PUT", vote, newToken, `{"vote": "none"}`, http.StatusOK},
+		{"downvote with karma", "PUT", vote, trustedToken, `{"vote": "down"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.method, tt.target, tt.token, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "karma") {
+				t.Errorf("body = %q, want the karma needed", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"myproject/store"
+)
+
+// Badges are awarded in the background: handlers only note whose badges may
+// have changed, and the worker evaluates them in batches.
+var (
+	badgeMu sync.Mutex
+	// badgeUsers and badgeComments are the users, and the authors of the
+	// comments, waiting to be evaluated.
+	badgeUsers    = make(map[int]bool)
+	badgeComments = make(map[int]bool)
+	badgeWake     = make(chan struct{}, 1)
+)
+
+func setupBadges() {
+	go awardBadges()
+}
+
+// checkBadges queues userID's badges for evaluation.
+func checkBadges(userID int) {
+	if userID == 0 {
+		return
+	}
+	badgeMu.Lock()
+	badgeUsers[userID] = true
+	badgeMu.Unlock()
+	wakeBadges()
+}
+
+// checkAuthorBadges queues the badges of commentID's author for evaluation,
+// saving the handler from looking the author up.
+func checkAuthorBadges(commentID int) {
+	badgeMu.Lock()
+	badgeComments[commentID] = true
+	badgeMu.Unlock()
+	wakeBadges()
+}
+
+func wakeBadges() {
+	select {
+	case badgeWake <- struct{}{}:
+	default:
+	}
+}
+
+// awardBadges evaluates queued users until the process exits. Work queued
+// at shutdown is lost, but the badges are awarded on the user's next check.
+func awardBadges() {
+	for range badgeWake {
+		badgeMu.Lock()
+		users, comments := badgeUsers, badgeComments
+		badgeUsers, badgeComments = make(map[int]bool), make(map[int]bool)
+		badgeMu.Unlock()
+
+		ctx := context.Background()
+		for commentID := range comments {
+			c, err := repo.Comments.Get(ctx, commentID, 0)
+			if err != nil {
+				log.Println("Error loading comment for badges:", err)
+				continue
+			}
+			if c.UserID != 0 {
+				users[c.UserID] = true
+			}
+		}
+		ids := make([]int, 0, len(users))
+		for id := range users {
+			ids = append(ids, id)
+		}
+
+		awards, err := repo.Badges.Award(ctx, ids)
+		if err != nil {
+			log.Println("Error awarding badges:", err)
+			continue
+		}
+		for _, a := range awards {
+			log.Printf("Awarded badge %s to user %d", a.Badge, a.UserID)
+		}
+	}
+}
+
+// listBadges serves GET /badges, every badge that can be earned.
+func listBadges(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, store.Badges)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+)
+
+// commentIDVar parses the {id} path segment of the /comments/{id} routes.
+func commentIDVar(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// sortError lists the accepted sorts, e.g. "sort must be one of best, ...,
+// oldest or top".
+var sortError = func() string {
+	names := make([]string, 0)
+	for _, s := range store.Sorts() {
+		names = append(names, string(s))
+	}
+	last := len(names) - 1
+	return "sort must be one of " + strings.Join(names[:last], ", ") + " or " + names[last]
+}()
+
+// pageParams reads the sort, limit and cursor query parameters shared by the
+// comment listings.
+func pageParams(w http.ResponseWriter, r *http.Request) (store.Page, bool) {
+	query := r.URL.Query()
+
+	sort, err := store.ParseSort(query.Get("sort"))
+	if err != nil {
+		http.Error(w, sortError, http.StatusBadRequest)
+		return store.Page{}, false
+	}
+
+	limit := defaultPageSize
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return store.Page{}, false
+		}
+	}
+
+	return store.Page{Sort: sort, Limit: limit, Cursor: query.Get("cursor")}, true
+}
+
+// writePage writes one page of a listing. The body stays a plain array; the
+// cursor for the next page travels in the X-Next-Cursor header and is absent
+// on the last page.
+func writePage[T any](w http.ResponseWriter, items []T, next string, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	writeJSON(w, items)
+}
+
+// editComment lets the author replace a comment's body. The previous body is
+// kept in the revision history.
+func editComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Comment        string `json:"comment"`
+		SentimentScore *int   `json:"sentiment_score"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Comment) == "" {
+		http.Error(w, "Comment must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	err := repo.Comments.Edit(r.Context(), id, viewerID(r), request.Comment, request.SentimentScore)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrForbidden):
+		http.Error(w, "Only the author can edit a comment", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Error editing comment", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	publishComment(r.Context(), eventCommentEdited, id)
+	recordMentions(r.Context(), id, viewerID(r), request.Comment)
+
+	c, err := repo.Comments.Get(r.Context(), id, viewerID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, c)
+}
+
+func getCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := repo.Comments.Get(r.Context(), id, 0); errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	revisions, err := repo.Comments.Revisions(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, revisions)
+}
+
+// deleteComment tombstones a comment. Replies stay visible under a
+// "[deleted]" placeholder. Admins may delete any comment.
+func deleteComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	user, _ := currentUser(r)
+	byAuthor := user.ID
+	if user.IsAdmin {
+		byAuthor = 0
+	}
+
+	err := repo.Comments.SoftDelete(r.Context(), id, byAuthor)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrForbidden):
+		http.Error(w, "Only the author can delete a comment", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Error deleting comment", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	publishComment(r.Context(), eventCommentDeleted, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultThreadDepth = 5
+	maxThreadDepth     = 10
+)
+
+// getThread returns a comment and its reply tree. depth bounds how many
+// levels of replies are included; sort and limit apply to the replies of
+// each comment, and next_cursor continues them through /replie/{comment_id}.
+func getThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	if page.Cursor != "" {
+		http.Error(w, "cursor is not supported here; use /replie/{comment_id}", http.StatusBadRequest)
+		return
+	}
+
+	depth := defaultThreadDepth
+	if v := r.URL.Query().Get("depth"); v != "" {
+		var err error
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 0 || depth > maxThreadDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 0 and %d", maxThreadDepth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	thread, err := repo.Comments.Thread(r.Context(), id, viewerID(r), depth, page)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, thread)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"myproject/store"
+	"myproject/urlcanon"
+)
+
+// Config holds everything that differs between environments. It is built from
+// defaults, then an optional YAML file, then URLEXT_* environment variables,
+// each layer overriding the previous one.
+type Config struct {
+	DBPath string `yaml:"db_path"`
+	// AutoMigrate applies pending schema migrations at startup. When false
+	// the server refuses to start until `migrate up` has been run.
+	AutoMigrate bool           `yaml:"auto_migrate"`
+	ListenAddr  string         `yaml:"listen_addr"`
+	Graph       GraphConfig    `yaml:"graph"`
+	Services    ServicesConfig `yaml:"services"`
+	Auth        AuthConfig     `yaml:"auth"`
+	URLs        URLConfig      `yaml:"urls"`
+	Webhooks    WebhookConfig  `yaml:"webhooks"`
+	Reactions   ReactionConfig `yaml:"reactions"`
+	Karma       KarmaConfig    `yaml:"karma"`
+}
+
+// KarmaConfig sets the karma users need for some actions; unset thresholds
+// are not enforced. Karma can be negative.
+type KarmaConfig struct {
+	MinToComment  *float64 `yaml:"min_to_comment"`
+	MinToDownvote *float64 `yaml:"min_to_downvote"`
+}
+
+// ReactionConfig lists the reactions users can leave on comments. The "like"
+// and "dislike" kinds are required; they back the vote counts and sorts.
+type ReactionConfig struct {
+	Kinds []ReactionKind `yaml:"kinds"`
+	// OnePerUser makes every reaction replace the user's previous one. Like
+	// and dislike always exclude each other.
+	OnePerUser bool `yaml:"one_per_user"`
+}
+
+type ReactionKind struct {
+	Name  string `yaml:"name" json:"name"`
+	Emoji string `yaml:"emoji" json:"emoji"`
+}
+
+// WebhookConfig controls delivery of outbound webhooks.
+type WebhookConfig struct {
+	// Timeout bounds each delivery attempt.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts is how often a delivery is tried before it is marked
+	// failed. Retries back off exponentially from RetryDelay.
+	MaxAttempts int           `yaml:"max_attempts"`
+	RetryDelay  time.Duration `yaml:"retry_delay"`
+}
+
+// URLConfig controls how page URLs are canonicalized before comments are
+// stored or looked up; see package urlcanon.
+type URLConfig struct {
+	// StripParams are query parameters dropped from page URLs. A trailing
+	// "*" matches any suffix.
+	StripParams []string `yaml:"strip_params"`
+	// StripWWW makes www.example.com and example.com share threads.
+	StripWWW bool `yaml:"strip_www"`
+}
+
+type AuthConfig struct {
+	// SessionTTL is how long a login token stays valid.
+	SessionTTL    time.Duration        `yaml:"session_ttl"`
+	OIDCProviders []OIDCProviderConfig `yaml:"oidc_providers"`
+}
+
+// OIDCProviderConfig describes one OpenID Connect identity provider. The
+// client secret may also come from URLEXT_OIDC_<NAME>_CLIENT_SECRET.
+type OIDCProviderConfig struct {
+	Name         string   `yaml:"name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+	// ReturnURLs are the only places the callback may send the browser
+	// afterwards, e.g. the extension's https://<id>.chromiumapp.org/ URL.
+	ReturnURLs []string `yaml:"return_urls"`
+}
+
+// GraphConfig selects and configures the social graph backend.
+type GraphConfig struct {
+	Backend string      `yaml:"backend"`
+	Neo4j   Neo4jConfig `yaml:"neo4j"`
+}
+
+type Neo4jConfig struct {
+	URI      string `yaml:"uri"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// ServicesConfig points at the Python ML services (sentiment.py, summary.py).
+type ServicesConfig struct {
+	SentimentURL string        `yaml:"sentiment_url"`
+	SummaryURL   string        `yaml:"summary_url"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+var (
+	providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	reactionName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+func defaultConfig() Config {
+	return Config{
+		DBPath:      "comments.db",
+		AutoMigrate: true,
+		ListenAddr:  "0.0.0.0:8080",
+		Graph: GraphConfig{
+			Backend: "sqlite",
+			Neo4j: Neo4jConfig{
+				Username: "neo4j",
+			},
+		},
+		Services: ServicesConfig{
+			SentimentURL: "http://localhost:5000/analyze_sentiment",
+			SummaryURL:   "http://localhost:6000/summarize",
+			Timeout:      30 * time.Second,
+		},
+		Auth: AuthConfig{
+			SessionTTL: 30 * 24 * time.Hour,
+		},
+		URLs: URLConfig{
+			StripParams: urlcanon.DefaultStripParams,
+			StripWWW:    true,
+		},
+		Webhooks: WebhookConfig{
+			Timeout:     10 * time.Second,
+			MaxAttempts: 8,
+			RetryDelay:  30 * time.Second,
+		},
+		Reactions: ReactionConfig{
+			Kinds: []ReactionKind{
+				{Name: "like", Emoji: "👍"},
+				{Name: "dislike", Emoji: "👎"},
+				{Name: "love", Emoji: "❤️"},
+				{Name: "laugh", Emoji: "😂"},
+				{Name: "wow", Emoji: "😮"},
+				{Name: "sad", Emoji: "😢"},
+				{Name: "angry", Emoji: "😠"},
+			},
+		},
+	}
+}
+
+// loadConfig reads the YAML file at path (if path is non-empty), applies
+// environment overrides and validates the result.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	vars := map[string]*string{
+		"URLEXT_DB_PATH":        &c.DBPath,
+		"URLEXT_LISTEN_ADDR":    &c.ListenAddr,
+		"URLEXT_GRAPH_BACKEND":  &c.Graph.Backend,
+		"URLEXT_NEO4J_URI":      &c.Graph.Neo4j.URI,
+		"URLEXT_NEO4J_USERNAME": &c.Graph.Neo4j.Username,
+		"URLEXT_NEO4J_PASSWORD": &c.Graph.Neo4j.Password,
+		"URLEXT_SENTIMENT_URL":  &c.Services.SentimentURL,
+		"URLEXT_SUMMARY_URL":    &c.Services.SummaryURL,
+	}
+	for key, dst := range vars {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+
+	bools := map[string]*bool{
+		"URLEXT_AUTO_MIGRATE":           &c.AutoMigrate,
+		"URLEXT_URLS_STRIP_WWW":         &c.URLs.StripWWW,
+		"URLEXT_REACTIONS_ONE_PER_USER": &c.Reactions.OnePerUser,
+	}
+	for key, dst := range bools {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = b
+		}
+	}
+	if v, ok := os.LookupEnv("URLEXT_WEBHOOK_MAX_ATTEMPTS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("URLEXT_WEBHOOK_MAX_ATTEMPTS: %w", err)
+		}
+		c.Webhooks.MaxAttempts = n
+	}
+	thresholds := map[string]**float64{
+		"URLEXT_KARMA_MIN_TO_COMMENT":  &c.Karma.MinToComment,
+		"URLEXT_KARMA_MIN_TO_DOWNVOTE": &c.Karma.MinToDownvote,
+	}
+	for key, dst := range thresholds {
+		if v, ok := os.LookupEnv(key); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = &f
+		}
+	}
+	if v, ok := os.LookupEnv("URLEXT_URLS_STRIP_PARAMS"); ok {
+		c.URLs.StripParams = strings.Split(v, ",")
+	}
+	for i := range c.Auth.OIDCProviders {
+		p := &c.Auth.OIDCProviders[i]
+		key := "URLEXT_OIDC_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_CLIENT_SECRET"
+		if v, ok := os.LookupEnv(key); ok {
+			p.ClientSecret = v
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"URLEXT_SERVICES_TIMEOUT": &c.Services.Timeout,
+		"URLEXT_SESSION_TTL":      &c.Auth.SessionTTL,
+		"URLEXT_WEBHOOK_TIMEOUT":  &c.Webhooks.Timeout,
+		"URLEXT_WEBHOOK_RETRY":    &c.Webhooks.RetryDelay,
+	}
+	for key, dst := range durations {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = d
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("db_path must not be empty"))
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr %q: %w", c.ListenAddr, err))
+	}
+
+	switch c.Graph.Backend {
+	case "sqlite":
+	case "neo4j":
+		if c.Graph.Neo4j.URI == "" {
+			errs = append(errs, errors.New("graph.neo4j.uri is required for the neo4j backend"))
+		}
+		if c.Graph.Neo4j.Password == "" {
+			errs = append(errs, errors.New("graph.neo4j.password is required for the neo4j backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("graph.backend %q is not supported", c.Graph.Backend))
+	}
+
+	for name, raw := range map[string]string{
+		"services.sentiment_url": c.Services.SentimentURL,
+		"services.summary_url":   c.Services.SummaryURL,
+	} {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s %q must be an absolute http(s) URL", name, raw))
+		}
+	}
+	if c.Services.Timeout <= 0 {
+		errs = append(errs, errors.New("services.timeout must be positive"))
+	}
+	if c.Auth.SessionTTL <= 0 {
+		errs = append(errs, errors.New("auth.session_ttl must be positive"))
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range c.Auth.OIDCProviders {
+		prefix := fmt.Sprintf("auth.oidc_providers[%d]", i)
+		if !providerName.MatchString(p.Name) {
+			errs = append(errs, fmt.Errorf("%s.name %q must match %s", prefix, p.Name, providerName))
+		} else if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("%s.name %q is used twice", prefix, p.Name))
+		}
+		seen[p.Name] = true
+		if p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("%s.client_id is required", prefix))
+		}
+		for field, raw := range map[string]string{"issuer": p.Issuer, "redirect_url": p.RedirectURL} {
+			if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s.%s %q must be an absolute URL", prefix, field, raw))
+			}
+		}
+	}
+
+	for _, p := range c.URLs.StripParams {
+		if name := strings.TrimSuffix(p, "*"); name == "" || strings.Contains(name, "*") {
+			errs = append(errs, fmt.Errorf("urls.strip_params entry %q must be a name with an optional trailing *", p))
+		}
+	}
+
+	kinds := make(map[string]bool)
+	for i, k := range c.Reactions.Kinds {
+		prefix := fmt.Sprintf("reactions.kinds[%d]", i)
+		if !reactionName.MatchString(k.Name) {
+			errs = append(errs, fmt.Errorf("%s.name %q must match %s", prefix, k.Name, reactionName))
+		} else if kinds[k.Name] {
+			errs = append(errs, fmt.Errorf("%s.name %q is used twice", prefix, k.Name))
+		}
+		kinds[k.Name] = true
+		if k.Emoji == "" {
+			errs = append(errs, fmt.Errorf("%s.emoji is required", prefix))
+		}
+	}
+	for _, required := range []string{store.ReactionLike, store.ReactionDislike} {
+		if !kinds[required] {
+			errs = append(errs, fmt.Errorf("reactions.kinds must include %q", required))
+		}
+	}
+
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, errors.New("webhooks.timeout must be positive"))
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, errors.New("webhooks.max_attempts must be at least 1"))
+	}
+	if c.Webhooks.RetryDelay <= 0 {
+		errs = append(errs, errors.New("webhooks.retry_delay must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := defaultConfig(); !reflect.DeepEqual(c, want) {
+		t.Errorf("loadConfig(\"\") = %+v, want the defaults %+v", c, want)
+	}
+}
+
+// TestLoadConfigLayers checks that the file overrides the defaults and the
+// environment overrides the file.
+func TestLoadConfigLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+db_path: /var/lib/urlext/comments.db
+listen_addr: 127.0.0.1:9000
+webhooks:
+  max_attempts: 3
+auth:
+  oidc_providers:
+    - name: my-idp
+      issuer: https://idp.example
+      client_id: urlext
+      client_secret: from-file
+      redirect_url: https://urlext.example/auth/oidc/my-idp/callback
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"URLEXT_LISTEN_ADDR":               ":8081",
+		"URLEXT_AUTO_MIGRATE":              "false",
+		"URLEXT_REACTIONS_ONE_PER_USER":    "true",
+		"URLEXT_WEBHOOK_MAX_ATTEMPTS":      "5",
+		"URLEXT_SESSION_TTL":               "1h",
+		"URLEXT_KARMA_MIN_TO_COMMENT":      "-2.5",
+		"URLEXT_URLS_STRIP_PARAMS":         "utm_*,ref",
+		"URLEXT_OIDC_MY_IDP_CLIENT_SECRET": "from-env",
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"db_path", c.DBPath, "/var/lib/urlext/comments.db"},
+		{"listen_addr", c.ListenAddr, ":8081"},
+		{"auto_migrate", c.AutoMigrate, false},
+		{"reactions.one_per_user", c.Reactions.OnePerUser, true},
+		{"webhooks.max_attempts", c.Webhooks.MaxAttempts, 5},
+		{"webhooks.retry_delay", c.Webhooks.RetryDelay, 30 * time.Second},
+		{"auth.session_ttl", c.Auth.SessionTTL, time.Hour},
+		{"karma.min_to_comment", *c.Karma.MinToComment, -2.5},
+		{"karma.min_to_downvote", c.Karma.MinToDownvote, (*float64)(nil)},
+		{"urls.strip_params", c.URLs.StripParams, []string{"utm_*", "ref"}},
+		{"client_secret", c.Auth.OIDCProviders[0].ClientSecret, "from-env"},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("db_path: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"missing file", filepath.Join(dir, "missing.yaml"), nil, "reading config file"},
+		{"invalid yaml", invalid, nil, "parsing config file"},
+		{"bool", "", map[string]string{"URLEXT_AUTO_MIGRATE": "maybe"}, "URLEXT_AUTO_MIGRATE"},
+		{"int", "", map[string]string{"URLEXT_WEBHOOK_MAX_ATTEMPTS": "many"}, "URLEXT_WEBHOOK_MAX_ATTEMPTS"},
+		{"float", "", map[string]string{"URLEXT_KARMA_MIN_TO_DOWNVOTE": "some"}, "URLEXT_KARMA_MIN_TO_DOWNVOTE"},
+		{"duration", "", map[string]string{"URLEXT_SESSION_TTL": "forever"}, "URLEXT_SESSION_TTL"},
+		{"validation", "", map[string]string{"URLEXT_DB_PATH": ""}, "db_path must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := loadConfig(tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	provider := OIDCProviderConfig{
+		Name:        "idp",
+		Issuer:      "https://idp.example",
+		ClientID:    "urlext",
+		RedirectURL: "https://urlext.example/auth/oidc/idp/callback",
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"db path", func(c *Config) { c.DBPath = "" }, "db_path must not be empty"},
+		{"listen addr", func(c *Config) { c.ListenAddr = "8080" }, `listen_addr "8080"`},
+		{"graph backend", func(c *Config) { c.Graph.Backend = "redis" }, `graph.backend "redis" is not supported`},
+		{"neo4j uri", func(c *Config) {
+			c.Graph.Backend = "neo4j"
+			c.Graph.Neo4j.Password = "secret"
+		}, "graph.neo4j.uri is required"},
+		{"neo4j password", func(c *Config) {
+			c.Graph.Backend = "neo4j"
+			c.Graph.Neo4j.URI = "bolt://localhost:7687"
+		}, "graph.neo4j.password is required"},
+		{"sentiment url", func(c *Config) { c.Services.SentimentURL = "localhost:5000" }, "services.sentiment_url"},
+		{"summary url", func(c *Config) { c.Services.SummaryURL = "ftp://host/" }, "services.summary_url"},
+		{"services timeout", func(c *Config) { c.Services.Timeout = 0 }, "services.timeout must be positive"},
+		{"session ttl", func(c *Config) { c.Auth.SessionTTL = -time.Hour }, "auth.session_ttl must be positive"},
+		{"provider", func(c *Config) { c.Auth.OIDCProviders = []OIDCProviderConfig{provider} }, ""},
+		{"provider name", func(c *Config) {
+			p := provider
+			p.Name = "My IdP"
+			c.Auth.OIDCProviders = []OIDCProviderConfig{p}
+		}, `auth.oidc_providers[0].name "My IdP" must match`},
+		{"duplicate provider", func(c *Config) {
+			c.Auth.OIDCProviders = []OIDCProviderConfig{provider, provider}
+		}, `auth.oidc_providers[1].name "idp" is used twice`},
+		{"client id", func(c *Config) {
+			p := provider
+			p.ClientID = ""
+			c.Auth.OIDCProviders = []OIDCProviderConfig{p}
+		}, "auth.oidc_providers[0].client_id is required"},
+		{"issuer", func(c *Config) {
+			p := provider
+			p.Issuer = "idp.example"
+			c.Auth.OIDCProviders = []OIDCProviderConfig{p}
+		}, "auth.oidc_providers[0].issuer"},
+		{"redirect url", func(c *Config) {
+			p := provider
+			p.RedirectURL = "/callback"
+			c.Auth.OIDCProviders = []OIDCProviderConfig{p}
+		}, "auth.oidc_providers[0].redirect_url"},
+		{"strip params", func(c *Config) { c.URLs.StripParams = []string{"utm_*", "*"} }, `urls.strip_params entry "*"`},
+		{"strip params inner star", func(c *Config) { c.URLs.StripParams = []string{"a*b"} }, `urls.strip_params entry "a*b"`},
+		{"reaction name", func(c *Config) {
+			c.Reactions.Kinds = append(c.Reactions.Kinds, ReactionKind{Name: "Heart", Emoji: "💜"})
+		}, `reactions.kinds[7].name "Heart" must match`},
+		{"duplicate reaction", func(c *Config) {
+			c.Reactions.Kinds = append(c.Reactions.Kinds, ReactionKind{Name: "love", Emoji: "💜"})
+		}, `reactions.kinds[7].name "love" is used twice`},
+		{"reaction emoji", func(c *Config) { c.Reactions.Kinds[2].Emoji = "" }, "reactions.kinds[2].emoji is required"},
+		{"required reaction", func(c *Config) { c.Reactions.Kinds = c.Reactions.Kinds[1:] }, `reactions.kinds must include "like"`},
+		{"webhook timeout", func(c *Config) { c.Webhooks.Timeout = 0 }, "webhooks.timeout must be positive"},
+		{"webhook attempts", func(c *Config) { c.Webhooks.MaxAttempts = 0 }, "webhooks.max_attempts must be at least 1"},
+		{"webhook retry delay", func(c *Config) { c.Webhooks.RetryDelay = 0 }, "webhooks.retry_delay must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			tt.modify(&c)
+			err := c.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Every problem is reported at once.
+	c := defaultConfig()
+	c.DBPath = ""
+	c.Webhooks.MaxAttempts = 0
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "db_path") || !strings.Contains(err.Error(), "max_attempts") {
+		t.Errorf("validate() = %v, want both errors", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"myproject/store"
+)
+
+// setupTestGraph points graph at the SQLite backend over the test database.
+func setupTestGraph(t *testing.T) {
+	t.Helper()
+	var err error
+	graph, err = newSocialGraph(GraphConfig{Backend: "sqlite"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestComment(t *testing.T, author store.User) int {
+	t.Helper()
+	id, err := repo.Comments.Create(context.Background(), &store.Comment{
+		URL: "https://example.com/", UserID: author.ID, Username: author.Username, Comment: "hi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestConnectUsers(t *testing.T) {
+	setupTestRepo(t)
+	setupTestGraph(t)
+	ctx := context.Background()
+
+	author, authorToken := createTestUser(t, "author", "")
+	reader, readerToken := createTestUser(t, "reader", "")
+	bystander, _ := createTestUser(t, "bystander", "")
+	comment, deleted := createTestComment(t, author), createTestComment(t, author)
+	if err := repo.Comments.SoftDelete(ctx, deleted, author.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"not logged in", "", fmt.Sprintf(`{"com_id": %d}`, comment), http.StatusUnauthorized},
+		{"invalid JSON", readerToken, `{`, http.StatusBadRequest},
+		{"missing comment", readerToken, fmt.Sprintf(`{"com_id": %d}`, deleted+1), http.StatusNotFound},
+		{"deleted comment", readerToken, fmt.Sprintf(`{"com_id": %d}`, deleted), http.StatusBadRequest},
+		// user_id_2 is ignored: the author is always the comment's.
+		{"connect", readerToken, fmt.Sprintf(`{"com_id": %d, "user_id_2": %d}`, comment, bystander.ID), http.StatusOK},
+		{"connect again", readerToken, fmt.Sprintf(`{"com_id": %d}`, comment), http.StatusOK},
+		{"own comment", authorToken, fmt.Sprintf(`{"com_id": %d}`, comment), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(t, "POST", "/connect_users", tt.token, tt.body); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	if connected, err := graph.ConnectedWithin(ctx, reader.ID, 1); err != nil || !slices.Equal(connected, []int{author.ID}) {
+		t.Errorf("reader is connected with %v, %v; want only the author", connected, err)
+	}
+
+	notifications := []struct {
+		user store.User
+		want int
+	}{
+		{author, 1},
+		{bystander, 0},
+		{reader, 0},
+	}
+	for _, n := range notifications {
+		if got, err := repo.Notifications.UnreadCount(ctx, n.user.ID); err != nil || got != n.want {
+			t.Errorf("%s has %d notifications, %v; want %d", n.user.Username, got, err, n.want)
+		}
+	}
+}
+
+func TestDisconnectUsers(t *testing.T) {
+	setupTestRepo(t)
+	setupTestGraph(t)
+	ctx := context.Background()
+
+	author, _ := createTestUser(t, "author", "")
+	reader, readerToken := createTestUser(t, "reader", "")
+	bystander, _ := createTestUser(t, "bystander", "")
+	first, second := createTestComment(t, author), createTestComment(t, author)
+	mine := createTestComment(t, reader)
+	for _, id := range []int{first, second} {
+		if rec := serve(t, "POST", "/connect_users", readerToken, fmt.Sprintf(`{"com_id": %d}`, id)); rec.Code != http.StatusOK {
+			t.Fatalf("connect: status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if err := graph.Connect(ctx, reader.ID, bystander.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		want      int
+		connected []int
+	}{
+		{"missing comment", fmt.Sprintf(`{"com_id": %d}`, mine+1), http.StatusNotFound, []int{author.ID, bystander.ID}},
+		{"own comment", fmt.Sprintf(`{"com_id": %d}`, mine), http.StatusBadRequest, []int{author.ID, bystander.ID}},
+		// user_id_2 is ignored, and the second comment still connects them.
+		{"first comment", fmt.Sprintf(`{"com_id": %d, "user_id_2": %d}`, first, bystander.ID), http.StatusOK, []int{author.ID, bystander.ID}},
+		{"last comment", fmt.Sprintf(`{"com_id": %d}`, second), http.StatusOK, []int{bystander.ID}},
+		{"again", fmt.Sprintf(`{"com_id": %d}`, second), http.StatusOK, []int{bystander.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(t, "DELETE", "/disconnect_users", readerToken, tt.body); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			connected, err := graph.ConnectedWithin(ctx, reader.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(connected)
+			if !slices.Equal(connected, tt.connected) {
+				t.Errorf("reader is connected with %v, want %v", connected, tt.connected)
+			}
+		})
+	}
+}
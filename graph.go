@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// SocialGraph stores the undirected "connected" relation between users.
+type SocialGraph interface {
+	Connect(ctx context.Context, userID1, userID2 int) error
+	Disconnect(ctx context.Context, userID1, userID2 int) error
+	// ConnectedWithin returns every user reachable from userID in at most
+	// depth hops, excluding userID itself.
+	ConnectedWithin(ctx context.Context, userID, depth int) ([]int, error)
+	Close() error
+}
+
+// newSocialGraph builds the backend selected in cfg.
+func newSocialGraph(cfg GraphConfig, db *sql.DB) (SocialGraph, error) {
+	switch cfg.Backend {
+	case "sqlite":
+		return &sqliteGraph{db: db}, nil
+	case "neo4j":
+		driver, err := neo4j.NewDriver(cfg.Neo4j.URI, neo4j.BasicAuth(cfg.Neo4j.Username, cfg.Neo4j.Password, ""))
+		if err != nil {
+			return nil, err
+		}
+		return &neo4jGraph{driver: driver}, nil
+	}
+	return nil, fmt.Errorf("unknown graph backend %q", cfg.Backend)
+}
+
+// neo4jGraph keeps connections as CONNECTED relationships between User nodes.
+type neo4jGraph struct {
+	driver neo4j.Driver
+}
+
+func (g *neo4jGraph) Connect(ctx context.Context, userID1, userID2 int) error {
+	session := g.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.Run(
+		"MERGE (u1:User {id: $userID1}) MERGE (u2:User {id: $userID2}) MERGE (u1)-[:CONNECTED]->(u2)",
+		map[string]interface{}{
+			"userID1": userID1,
+			"userID2": userID2,
+		},
+	)
+	return err
+}
+
+func (g *neo4jGraph) Disconnect(ctx context.Context, userID1, userID2 int) error {
+	session := g.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.Run(
+		`MATCH (u1:User {id: $userID1})-[r:CONNECTED]-(u2:User {id: $userID2}) DELETE r`,
+		map[string]interface{}{
+			"userID1": userID1,
+			"userID2": userID2,
+		},
+	)
+	return err
+}
+
+func (g *neo4jGraph) ConnectedWithin(ctx context.Context, userID, depth int) ([]int, error) {
+	session := g.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	// Variable-length bounds cannot be parameters in Cypher.
+	query := fmt.Sprintf(`
+		MATCH (u:User {id: $userID})-[:CONNECTED*1..%d]-(connectedUser)
+		WHERE connectedUser.id <> $userID
+		RETURN DISTINCT connectedUser.id AS userID
+	`, depth)
+
+	result, err := session.Run(query, map[string]interface{}{"userID": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []int
+	for result.Next() {
+		if id, ok := result.Record().Get("userID"); ok {
+			if intID, ok := id.(int64); ok {
+				userIDs = append(userIDs, int(intID))
+			}
+		}
+	}
+	return userIDs, result.Err()
+}
+
+func (g *neo4jGraph) Close() error {
+	return g.driver.Close()
+}
+
+// sqliteGraph stores each connection once in user_connections and walks it
+// in both directions with a recursive CTE.
+type sqliteGraph struct {
+	db *sql.DB
+}
+
+func (g *sqliteGraph) Connect(ctx context.Context, userID1, userID2 int) error {
+	_, err := g.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO user_connections (user_id_1, user_id_2) VALUES (?, ?)`,
+		userID1, userID2)
+	return err
+}
+
+func (g *sqliteGraph) Disconnect(ctx context.Context, userID1, userID2 int) error {
+	_, err := g.db.ExecContext(ctx, `
+		DELETE FROM user_connections
+		WHERE (user_id_1 = ? AND user_id_2 = ?) OR (user_id_1 = ? AND user_id_2 = ?)`,
+		userID1, userID2, userID2, userID1)
+	return err
+}
+
+func (g *sqliteGraph) ConnectedWithin(ctx context.Context, userID, depth int) ([]int, error) {
+	rows, err := g.db.QueryContext(ctx, `
+		WITH RECURSIVE
+		edges(a, b) AS (
+			SELECT user_id_1, user_id_2 FROM user_connections
+			UNION
+			SELECT user_id_2, user_id_1 FROM user_connections
+		),
+		reach(id, depth) AS (
+			SELECT ?, 0
+			UNION
+			SELECT e.b, r.depth + 1 FROM reach r JOIN edges e ON e.a = r.id
+			WHERE r.depth < ?
+		)
+		SELECT DISTINCT id FROM reach WHERE id != ?`,
+		userID, depth, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+func (g *sqliteGraph) Close() error {
+	return nil
+}
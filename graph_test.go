@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSQLiteGraphConnectedWithin(t *testing.T) {
+	setupTestRepo(t)
+	ctx := context.Background()
+	g, err := newSocialGraph(GraphConfig{Backend: "sqlite"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 - 2 - 3 - 4 - 5, plus 6 - 1 stored in the other direction and a
+	// duplicate of 2 - 3 that must be ignored.
+	edges := [][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 5}, {6, 1}, {2, 3}, {3, 2}}
+	for _, e := range edges {
+		if err := g.Connect(ctx, e[0], e[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		depth  int
+		want   []int
+	}{
+		{"direct", 1, 1, []int{2, 6}},
+		{"two hops", 1, 2, []int{2, 3, 6}},
+		{"three hops", 1, 3, []int{2, 3, 4, 6}},
+		{"reverse direction", 5, 2, []int{3, 4}},
+		{"middle", 3, 1, []int{2, 4}},
+		{"unconnected", 99, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.ConnectedWithin(ctx, tt.userID, tt.depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ConnectedWithin(%d, %d) = %v, want %v", tt.userID, tt.depth, got, tt.want)
+			}
+		})
+	}
+
+	// Disconnecting works from either end.
+	if err := g.Disconnect(ctx, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := g.ConnectedWithin(ctx, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if want := []int{2, 6}; !slices.Equal(got, want) {
+		t.Errorf("after Disconnect(3, 2): ConnectedWithin(1, 3) = %v, want %v", got, want)
+	}
+}
+
+func TestNewSocialGraphUnknownBackend(t *testing.T) {
+	if _, err := newSocialGraph(GraphConfig{Backend: "redis"}, nil); err == nil {
+		t.Error("newSocialGraph accepted an unknown backend")
+	}
+}
@@ -1,219 +1,278 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"slices"
 	"strconv"
-	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
-	"io"
-	"bytes"
-	"time"
-	"math/rand"
-	"fmt"
-)
 
+	"github.com/gorilla/mux"
 
-const dbFile = "comments.db"
+	"myproject/store"
+)
 
-var neo4jDriver neo4j.Driver
+var cfg Config
 
+// graph holds user-to-user connections; see newSocialGraph.
+var graph SocialGraph
 
-type User struct {
-	ID       int    `json:"user_id"`
-	Username string `json:"username"`
-}
-
-// Comment struct
-type Comment struct {
-	ID             int    `json:"id"`
-	UserID         int    `json:"user_id"`
-	ParentID       *int   `json:"parent_id,omitempty"`
-	Comment        string `json:"comment"`
-	CreatedAt      string `json:"created_at"`
-	Username       string `json:"username"`
-	ProfilePic     string `json:"profile_pic"`
-	SentimentScore int    `json:"sentiment_score"`
-	ReplyCount     int    `json:"reply_count"`
-	LikeCount      int    `json:"like_count"`
-	DislikeCount   int    `json:"dislike_count"`
-	LikeStatus     *bool   `json:"like_status"`
-	ConStatus      *bool   `json:"con_status"`
-	URL            string `json:"url,omitempty"`
-}
-
+// mlClient is used for every call to the sentiment and summary services.
+var mlClient *http.Client
 
 var db *sql.DB
 
-func init() {
+// repo is the repository layer every handler goes through.
+var repo *store.Store
+
+// openDB opens the SQLite database named in the config.
+func openDB() {
 	var err error
-	db, err = sql.Open("sqlite3", dbFile)
+	db, err = sql.Open(store.DriverName, cfg.DBPath)
 	if err != nil {
 		log.Fatal("Error connecting to database:", err)
 	}
+}
+
+func setup() {
+	openDB()
+	setupURLs()
+	migrateOnStartup()
+	repo = store.NewSQLite(db)
+	if ok, err := store.EnableSearch(context.Background(), db); err != nil {
+		log.Println("Error enabling comment search:", err)
+	} else if !ok {
+		log.Println("Comment search is disabled: SQLite lacks FTS5; build with `-tags sqlite_fts5`")
+	}
+	if err := repo.Sessions.DeleteExpired(context.Background()); err != nil {
+		log.Println("Error deleting expired sessions:", err)
+	}
 
-	
-	createTables()
-	
-		
-	neo4jDriver, err = neo4j.NewDriver("neo4j+s://990afffb.databases.neo4j.io", neo4j.BasicAuth("neo4j", "C84lgfI-kHXJKLSBx6ncDC-ZRjuBdxSytWtvLTGYawc", ""))
+	var err error
+	graph, err = newSocialGraph(cfg.Graph, db)
 	if err != nil {
-		log.Fatal("Error connecting to Neo4j:", err)
+		log.Fatal("Error connecting to social graph:", err)
 	}
+
+	mlClient = &http.Client{Timeout: cfg.Services.Timeout}
+	setupOIDC()
+	setupWebhooks()
+	setupBadges()
 }
 
-func createTables() {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY,
-		username TEXT NOT NULL UNIQUE
-	);
-
-	CREATE TABLE IF NOT EXISTS comments (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT NOT NULL,
-		parent_id INTEGER,
-		user_id INTEGER NOT NULL,
-		username TEXT NOT NULL,
-		profile_pic TEXT,
-		comment TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		sentiment_score INTEGER DEFAULT 0,
-		FOREIGN KEY (user_id) REFERENCES users(id),
-		FOREIGN KEY (parent_id) REFERENCES comments(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS comment_likes (
-		id INTEGER PRIMARY KEY,
-		comment_id INTEGER NOT NULL,
-		user_id INTEGER NOT NULL,
-		is_like BOOLEAN NOT NULL,
-		FOREIGN KEY (comment_id) REFERENCES comments(id),
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-	
-	CREATE TABLE IF NOT EXISTS connection (
-		user_id INTEGER NOT NULL,
-		comment_id INTEGER NOT NULL
-	);	
-	
-	CREATE TABLE IF NOT EXISTS summaries (
-        url TEXT PRIMARY KEY,
-        summary TEXT NOT NULL
-        );`
-
-	_, err := db.Exec(query)
+// migrateOnStartup applies pending migrations, or refuses to start on an
+// outdated schema when auto_migrate is off.
+func migrateOnStartup() {
+	ctx := context.Background()
+	if !cfg.AutoMigrate {
+		pending, err := store.Pending(ctx, db)
+		if err != nil {
+			log.Fatal("Error checking migrations:", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database has %d pending migrations; run `migrate up`", len(pending))
+		}
+		return
+	}
+
+	applied, err := store.MigrateUp(ctx, db)
 	if err != nil {
-		log.Fatal("Error creating tables:", err)
+		log.Fatal("Error migrating database:", err)
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
 	}
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("URLEXT_CONFIG"), "path to a YAML config file")
+	flag.Parse()
+
+	var err error
+	cfg, err = loadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		openDB()
+		setupURLs()
+		os.Exit(runMigrate(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "admin" {
+		openDB()
+		os.Exit(runAdmin(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "canonicalize-urls" {
+		openDB()
+		os.Exit(runCanonicalizeURLs(flag.Args()[1:]))
+	}
+	setup()
+
 	r := mux.NewRouter()
 
-	
 	//r.Use(enableCORS)
 
+	r.Use(authenticate)
+
 	// Define Routes
-	r.HandleFunc("/login/{username}", login).Methods("GET")
+	r.HandleFunc("/register", register).Methods("POST")
+	r.HandleFunc("/login", login).Methods("POST")
+	r.HandleFunc("/logout", requireUser(logout)).Methods("POST")
+	r.HandleFunc("/password", requireUser(setPassword)).Methods("PUT")
+	r.HandleFunc("/claim", claimAccount).Methods("POST")
+	r.HandleFunc("/auth/oidc", listOIDCProviders).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/start", oidcStart).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", oidcCallback).Methods("GET")
+	r.HandleFunc("/users/suggest", suggestUsers).Methods("GET")
+	r.HandleFunc("/users/{id}", getProfile).Methods("GET")
+	r.HandleFunc("/users/{id}", requireUser(updateProfile)).Methods("PATCH")
 	r.HandleFunc("/comments", getComments).Methods("GET")
 	r.HandleFunc("/replie/{comment_id}", getReplies).Methods("GET")
-	r.HandleFunc("/comments", postComment).Methods("POST")
-	r.HandleFunc("/replies/{parent_id}", postReply).Methods("POST")
-	r.HandleFunc("/comment_like", commentLike).Methods("POST") 
-	r.HandleFunc("/connect_users", connectUsers).Methods("POST")
-	r.HandleFunc("/comments_by_connections", getCommentsByConnections).Methods("GET")
-	r.HandleFunc("/disconnect_users", disconnectUsers).Methods("DELETE")
+	r.HandleFunc("/sites/{domain}/comments", getSiteComments).Methods("GET")
+	r.HandleFunc("/search", searchComments).Methods("GET")
+	r.HandleFunc("/trending", getTrending).Methods("GET")
+	r.HandleFunc("/stream", streamComments).Methods("GET")
+	r.HandleFunc("/notifications", requireUser(getNotifications)).Methods("GET")
+	r.HandleFunc("/notifications/unread_count", requireUser(getUnreadCount)).Methods("GET")
+	r.HandleFunc("/notifications/read_all", requireUser(markAllNotificationsRead)).Methods("POST")
+	r.HandleFunc("/notifications/{id}/read", requireUser(markNotificationRead)).Methods("POST")
+	r.HandleFunc("/comments/{id}", requireUser(editComment)).Methods("PATCH")
+	r.HandleFunc("/comments/{id}", requireUser(deleteComment)).Methods("DELETE")
+	r.HandleFunc("/comments/{id}/revisions", getCommentRevisions).Methods("GET")
+	r.HandleFunc("/comments/{id}/thread", getThread).Methods("GET")
+	r.HandleFunc("/admin/comments/{id}", requireAdmin(purgeComment)).Methods("DELETE")
+	r.HandleFunc("/admin/webhooks", requireAdmin(createWebhook)).Methods("POST")
+	r.HandleFunc("/admin/webhooks", requireAdmin(listWebhooks)).Methods("GET")
+	r.HandleFunc("/admin/webhooks/{id}", requireAdmin(deleteWebhook)).Methods("DELETE")
+	r.HandleFunc("/admin/webhooks/{id}/deliveries", requireAdmin(getWebhookDeliveries)).Methods("GET")
+	r.HandleFunc("/admin/webhook_deliveries/{id}/redeliver", requireAdmin(redeliverWebhook)).Methods("POST")
+	r.HandleFunc("/comments", requireUser(postComment)).Methods("POST")
+	r.HandleFunc("/replies/{parent_id}", requireUser(postReply)).Methods("POST")
+	r.HandleFunc("/comment_like", requireUser(commentLike)).Methods("POST")
+	r.HandleFunc("/reactions", listReactions).Methods("GET")
+	r.HandleFunc("/badges", listBadges).Methods("GET")
+	r.HandleFunc("/comments/{id}/reactions/{kind}", requireUser(putReaction)).Methods("PUT", "DELETE")
+	r.HandleFunc("/comments/{id}/vote", requireUser(putVote)).Methods("PUT")
+	r.HandleFunc("/connect_users", requireUser(connectUsers)).Methods("POST")
+	r.HandleFunc("/comments_by_connections", requireUser(getCommentsByConnections)).Methods("GET")
+	r.HandleFunc("/disconnect_users", requireUser(disconnectUsers)).Methods("DELETE")
 	r.HandleFunc("/comment_summary", getCommentSummary).Methods("GET")
-	r.HandleFunc("/analyze_sentiment",getScore).Methods("GET")
-	
-	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080", r))
+	r.HandleFunc("/analyze_sentiment", getScore).Methods("GET")
+
+	log.Println("Server started on", cfg.ListenAddr)
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, r))
 }
 
 // CORS Middleware to allow cross-origin requests
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println("CORS middleware triggered") 
+		log.Println("CORS middleware triggered")
 
-		
-		//w.Header().Set("Access-Control-Allow-Origin", "*")  
-		//w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS") 
-		//w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization") 
+		//w.Header().Set("Access-Control-Allow-Origin", "*")
+		//w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		//w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
-		
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		
 		next.ServeHTTP(w, r)
 	})
 }
 
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// connectionAuthor returns the author of the comment a connection request
+// goes through, rejecting comments that are missing, deleted or the caller's
+// own. The user_id_2 that older clients send is ignored.
+func connectionAuthor(w http.ResponseWriter, r *http.Request, commentID int) (int, bool) {
+	c, err := repo.Comments.Get(r.Context(), commentID, 0)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return 0, false
+	}
+	// Deleted comments no longer name their author.
+	if c.UserID == 0 {
+		http.Error(w, "Comment has no author to connect with", http.StatusBadRequest)
+		return 0, false
+	}
+	if c.UserID == viewerID(r) {
+		http.Error(w, "Cannot connect with yourself", http.StatusBadRequest)
+		return 0, false
+	}
+	return c.UserID, true
+}
+
+// connectUsers connects the caller with the author of com_id.
 func connectUsers(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		UserID1 int `json:"user_id_1"`
-		UserID2 int `json:"user_id_2"`
-		ComID   int `json:"com_id"`
+		ComID int `json:"com_id"`
 	}
-	
-	
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	log.Println(request)
-	
-	query := `INSERT INTO connection (user_id, comment_id) VALUES (?, ?)`
-        _, err := db.Exec(query, request.UserID1, request.ComID)
-        if err != nil {
-            http.Error(w, "Error inserting comment table", http.StatusInternalServerError)
-            log.Println(err)
-            return
-        }
-
-	session := neo4jDriver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	defer session.Close()
-
-	_, err = session.Run(
-		"MERGE (u1:User {id: $userID1}) MERGE (u2:User {id: $userID2}) MERGE (u1)-[:CONNECTED]->(u2)",
-		map[string]interface{}{
-			"userID1": request.UserID1,
-			"userID2": request.UserID2,
-		},
-	)
-	if err != nil {
+	userID := viewerID(r)
+	log.Println(userID, request)
+
+	authorID, ok := connectionAuthor(w, r, request.ComID)
+	if !ok {
+		return
+	}
+
+	if err := repo.Connections.Add(r.Context(), userID, request.ComID); err != nil {
+		http.Error(w, "Error inserting comment table", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if err := graph.Connect(r.Context(), userID, authorID); err != nil {
 		http.Error(w, "Failed to connect users", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
+	notify(r.Context(), authorID, store.NotifyConnection, userID, request.ComID)
+	checkBadges(userID)
+	checkAuthorBadges(request.ComID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "Users connected"})
+	writeJSON(w, map[string]string{"status": "Users connected"})
 }
 
 func getScore(w http.ResponseWriter, r *http.Request) {
-        message := r.URL.Query().Get("message")
-
-        decodedmsg, err := url.QueryUnescape(message)
-        if err != nil {
-                http.Error(w, "Error decoding user ID", http.StatusBadRequest)
-                log.Println(err)
-                return
-        }
+	message := r.URL.Query().Get("message")
+
+	decodedmsg, err := url.QueryUnescape(message)
+	if err != nil {
+		http.Error(w, "Error decoding user ID", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
 	log.Println("score triggered")
-	url := "http://localhost:5000/analyze_sentiment"
 	requestBody := []byte(fmt.Sprintf(`{"text": ["%s"]}`, decodedmsg))
 
 	// Send request to ML model
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(requestBody))
+	resp, err := mlClient.Post(cfg.Services.SentimentURL, "application/json", bytes.NewBuffer(requestBody))
 	if err != nil {
 		http.Error(w, "Error calling sentiment analysis service", http.StatusInternalServerError)
 		log.Println("Error calling ML model:", err)
@@ -234,575 +293,351 @@ func getScore(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseBody)
 }
 
-
-
+// disconnectUsers removes the caller's connection through com_id. The
+// caller and its author stay connected in the graph while another
+// connection between them remains, in either direction.
 func disconnectUsers(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		UserID1 int `json:"user_id_1"`
-		UserID2 int `json:"user_id_2"`
-		ComID   int `json:"com_id"`
+		ComID int `json:"com_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	log.Println("Disconnecting:", request)
+	userID := viewerID(r)
+	log.Println("Disconnecting:", userID, request)
 
-	// Remove from SQL database
-	query := `DELETE FROM connection WHERE user_id = ? AND comment_id = ?`
-	_, err := db.Exec(query, request.UserID1, request.ComID)
-	if err != nil {
+	authorID, ok := connectionAuthor(w, r, request.ComID)
+	if !ok {
+		return
+	}
+
+	if err := repo.Connections.Remove(r.Context(), userID, request.ComID); err != nil {
 		http.Error(w, "Error deleting from connection table", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
 
-	// Remove from Neo4j graph
-	session := neo4jDriver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	defer session.Close()
-
-	_, err = session.Run(
-		`MATCH (u1:User {id: $userID1})-[r:CONNECTED]-(u2:User {id: $userID2}) DELETE r`,
-		map[string]interface{}{
-			"userID1": request.UserID1,
-			"userID2": request.UserID2,
-		},
-	)
+	connected, err := repo.Connections.Connected(r.Context(), userID, authorID)
 	if err != nil {
-		http.Error(w, "Failed to disconnect users", http.StatusInternalServerError)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
+	if !connected {
+		if err := graph.Disconnect(r.Context(), userID, authorID); err != nil {
+			http.Error(w, "Failed to disconnect users", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "Users disconnected"})
+	writeJSON(w, map[string]string{"status": "Users disconnected"})
 }
 
-
 func getCommentsByConnections(w http.ResponseWriter, r *http.Request) {
-	urlParam := r.URL.Query().Get("url")
-	IDparam := r.URL.Query().Get("user_id")
+	pageURL, ok := requestPageURL(w, r)
+	if !ok {
+		return
+	}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	userID := viewerID(r)
 
-	decodedURL, err := url.QueryUnescape(urlParam)
-	userID, err := strconv.Atoi(IDparam)
+	userIDs, err := graph.ConnectedWithin(r.Context(), userID, 3)
 	if err != nil {
-		http.Error(w, "Error decoding user ID", http.StatusBadRequest)
+		http.Error(w, "Failed to fetch connected users", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
+	log.Println("Connected user IDs:", userIDs)
 
-	session := neo4jDriver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
-	defer session.Close()
+	if len(userIDs) == 0 {
+		writeJSON(w, []interface{}{})
+		return
+	}
 
-	query := `
-		MATCH (u:User {id: $userID})-[:CONNECTED*1..3]-(connectedUser)
-		RETURN DISTINCT connectedUser.id AS userID
-	`
+	comments, next, err := repo.Comments.ListByAuthors(r.Context(), pageURL, userID, userIDs, page)
+	writePage(w, comments, next, err)
+}
 
-	result, err := session.Run(query, map[string]interface{}{"userID": userID})
+// Get comments for a specific URL. scope=path-prefix or scope=domain widens
+// the listing to every page below the URL's path or on its site.
+func getComments(w http.ResponseWriter, r *http.Request) {
+	pageURL, ok := requestPageURL(w, r)
+	if !ok {
+		return
+	}
+	filter, err := scopeFilter(pageURL, r.URL.Query().Get("scope"))
 	if err != nil {
-		http.Error(w, "Failed to fetch connected users", http.StatusInternalServerError)
-		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var userIDs []int
-	for result.Next() {
-		if id, ok := result.Record().Get("userID"); ok {
-			log.Println("Connected user IDs:", id)
-			if intID, ok := id.(int64); ok {
-				userIDs = append(userIDs, int(intID))
-			}
-		}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
 	}
 
-	if len(userIDs) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]interface{}{})
+	log.Printf("Fetching comments for URL: %s", pageURL)
+
+	comments, next, err := repo.Comments.ListTopLevel(r.Context(), filter, viewerID(r), page)
+	writePage(w, comments, next, err)
+}
+
+// Get replies for a specific comment
+func getReplies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.Atoi(vars["comment_id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
 		return
 	}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	replies, next, err := repo.Comments.ListReplies(r.Context(), commentID, viewerID(r), page)
+	writePage(w, replies, next, err)
+}
 
-	// Build SQL query with placeholders for user IDs
-	queryStr := `
-		SELECT c.id, c.user_id, c.parent_id, c.username, c.profile_pic, c.comment, c.created_at, c.sentiment_score,
-		       (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count,
-		       (SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1) AS like_count,
-		       (SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 0) AS dislike_count,
-		       (SELECT is_like FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = ?) AS like_status,
-		       EXISTS (
-		       		SELECT 1 FROM connection WHERE user_id = ? AND comment_id = c.id
-		       ) AS row_exists
-		FROM comments c WHERE c.user_id IN (`
-
-	args := []interface{}{userID, userID} // Pass userID for the first two `?` placeholders
+func getCommentSummary(w http.ResponseWriter, r *http.Request) {
+	pageURL, ok := requestPageURL(w, r)
+	if !ok {
+		return
+	}
 
-	for i, id := range userIDs {
-		if i > 0 {
-			queryStr += ","
-		}
-		queryStr += "?"
-		args = append(args, id) // Append user IDs to match `?` placeholders
+	// Check if summary exists in database
+	existingSummary, err := repo.Summaries.Get(r.Context(), pageURL)
+	if err == nil {
+		log.Println("Returning cached summary")
+		writeJSON(w, map[string]string{"summary": existingSummary})
+		return
 	}
 
-	queryStr += ") AND url = ?" // Add URL filtering
-	args = append(args, decodedURL) // Append the URL to the query parameters
+	comments, err := repo.Comments.BodiesByURL(r.Context(), pageURL)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
 
-	rows, err := db.Query(queryStr, args...)
+	summary, err := summarize(comments)
 	if err != nil {
-		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		http.Error(w, "Error calling summarize service", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
-	defer rows.Close()
 
-	var comments []Comment
-	for rows.Next() {
-		var c Comment
-		if err := rows.Scan(
-			&c.ID, &c.UserID, &c.ParentID, &c.Username, &c.ProfilePic,
-			&c.Comment, &c.CreatedAt, &c.SentimentScore, &c.ReplyCount,
-			&c.LikeCount, &c.DislikeCount, &c.LikeStatus, &c.ConStatus,
-		); err != nil {
-			log.Println("Error scanning comment:", err)
-			continue
-		}
-		comments = append(comments, c)
+	// Store the new summary in the database
+	if err := repo.Summaries.Put(r.Context(), pageURL, summary); err != nil {
+		log.Println("Error storing summary in database:", err)
+	} else {
+		fireWebhooks(r.Context(), webhookSummaryUpdated, pageURL, map[string]string{"summary": summary})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	writeJSON(w, map[string]string{"summary": summary})
 }
 
-// Login route to create or fetch a user
-func login(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	name := vars["username"]
-
-	log.Printf("Received login request for mail: %s", name)
-
-	
-	var userID int
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", name).Scan(&userID)
+// summarize asks the summary service to condense comments into one text.
+func summarize(comments []string) (string, error) {
+	requestBody, err := json.Marshal(map[string][]string{"messages": comments})
+	if err != nil {
+		return "", err
+	}
 
-	
-	if err == sql.ErrNoRows {
-		
-		userID = generateRandomUserID()
+	resp, err := mlClient.Post(cfg.Services.SummaryURL, "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
 
-		
-		_, err := db.Exec("INSERT INTO users (id, username) VALUES (?, ?)", userID, name)
-		if err != nil {
-			http.Error(w, "Error creating user", http.StatusInternalServerError)
-			log.Println(err)
-			return
-		}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading summarize response: %w", err)
 	}
 
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"user_id": userID})
+	var summaryResponse map[string]string
+	if err := json.Unmarshal(body, &summaryResponse); err != nil {
+		return "", fmt.Errorf("decoding summarize response: %w", err)
+	}
+	return summaryResponse["summary"], nil
 }
 
-// Generate a random 8-digit user ID
-func generateRandomUserID() int {
-	rand.Seed(time.Now().UnixNano()) // Seed the random number generator
-	return rand.Intn(90000000) + 10000000 // Ensures an 8-digit number (10000000 - 99999999)
-}
+// Post a comment
+func postComment(w http.ResponseWriter, r *http.Request) {
+	var c store.Comment
 
-// Get comments for a specific URL
-func getComments(w http.ResponseWriter, r *http.Request) {
-	
-	urlParam := r.URL.Query().Get("url")
-	idParam:=r.URL.Query().Get("user_id")
-	
-	
-	decodedURL, err := url.QueryUnescape(urlParam)
-	decodedID, err := url.QueryUnescape(idParam)
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !hasKarma(w, r, cfg.Karma.MinToComment, "comment") {
+		return
+	}
+	setAuthor(r, &c)
+	if !canonicalizeCommentURL(w, &c) {
+		return
+	}
+
+	commentID, err := repo.Comments.Create(r.Context(), &c)
 	if err != nil {
-		http.Error(w, "Error decoding URL", http.StatusBadRequest)
+		http.Error(w, "Database insert error", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
+	c.ID = commentID
+	publishComment(r.Context(), eventCommentCreated, c.ID)
+	recordMentions(r.Context(), c.ID, c.UserID, c.Comment)
+	checkBadges(c.UserID)
+
+	count, err := repo.Comments.CountByURL(r.Context(), c.URL)
+	if err == nil && count%5 == 0 {
+		go updateSummary(c.URL)
+	}
 
-	log.Printf("Fetching comments for URL: %s", decodedURL)
-	log.Printf("userID: %s", decodedID)
-	
+	writeJSON(w, map[string]int{"comment_id": c.ID})
+}
 
-	query := `
-	SELECT c.id, c.user_id, c.parent_id, c.comment, c.created_at, c.username, c.profile_pic, c.sentiment_score,
-	       (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count,
-	       (SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1) AS like_count,
-	       (SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 0) AS dislike_count,
-	       (SELECT is_like FROM comment_likes cl WHERE cl.comment_id=c.id AND cl.user_id=?) AS like_status,
-	       (SELECT EXISTS(
-    			SELECT 1 FROM connection WHERE user_id = ? AND comment_id = c.id
-		)) AS row_exists
-	       
-	FROM comments c
-	WHERE c.url = ? AND c.parent_id IS NULL
-	ORDER BY c.created_at ASC;
-	`
+// setAuthor overwrites any client-supplied author with the logged-in user.
+func setAuthor(r *http.Request, c *store.Comment) {
+	user, _ := currentUser(r)
+	c.UserID = user.ID
+	c.Username = user.Username
+}
 
-	rows, err := db.Query(query, decodedID, decodedID, decodedURL)
+func updateSummary(url string) {
+	ctx := context.Background()
+
+	comments, err := repo.Comments.BodiesByURL(ctx, url)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Println(err)
+		log.Println("Error fetching comments for summary update:", err)
 		return
 	}
-	defer rows.Close()
 
-	var comments []Comment
-	for rows.Next() {
-		var c Comment
-		if err := rows.Scan(&c.ID, &c.UserID, &c.ParentID, &c.Comment, &c.CreatedAt, &c.Username, &c.ProfilePic, &c.SentimentScore, &c.ReplyCount, &c.LikeCount, &c.DislikeCount, &c.LikeStatus,&c.ConStatus); err != nil {
-			http.Error(w, "Error scanning results", http.StatusInternalServerError)
-			log.Println(err)
-			return
-		}
-		comments = append(comments, c)
+	if len(comments) == 0 {
+		return
 	}
 
-	log.Printf("Comments retrieved: %+v", comments)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	summary, err := summarize(comments)
+	if err != nil {
+		log.Println("Error calling summarize service:", err)
+		return
+	}
+
+	if err := repo.Summaries.Put(ctx, url, summary); err != nil {
+		log.Println("Error updating summary table:", err)
+		return
+	}
+	fireWebhooks(ctx, webhookSummaryUpdated, url, map[string]string{"summary": summary})
 }
 
-// Get replies for a specific comment
-func getReplies(w http.ResponseWriter, r *http.Request) {
+// Post a reply
+func postReply(w http.ResponseWriter, r *http.Request) {
+	log.Println("triggered post")
+
+	var c store.Comment
 	vars := mux.Vars(r)
-	commentID := vars["comment_id"]
-	log.Println(commentID)
+	parentID := vars["parent_id"]
+
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !hasKarma(w, r, cfg.Karma.MinToComment, "comment") {
+		return
+	}
+	setAuthor(r, &c)
 
-	query := `SELECT * FROM comments WHERE parent_id = ?`
-	rows, err := db.Query(query, commentID)
+	parentIDInt, err := strconv.Atoi(parentID)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Println(err)
+		log.Println("Invalid parent ID:", parentID)
+		http.Error(w, "Invalid parent ID", http.StatusBadRequest)
+		return
+	}
+	c.ParentID = &parentIDInt
+	if !canonicalizeCommentURL(w, &c) {
 		return
 	}
-	defer rows.Close()
 
-	var replies []Comment
-	for rows.Next() {
-		var c Comment
-		if err := rows.Scan(&c.ID, &c.URL, &c.ParentID, &c.UserID, &c.Username, &c.ProfilePic, &c.Comment, &c.CreatedAt, &c.SentimentScore); err != nil {
-			http.Error(w, "Error scanning results", http.StatusInternalServerError)
-			log.Println(err)
-			return
-		}
-		replies = append(replies, c)
+	commentID, err := repo.Comments.Create(r.Context(), &c)
+	if err != nil {
+		log.Println("Error executing query:", err)
+		http.Error(w, "Database insert error", http.StatusInternalServerError)
+		return
 	}
+	c.ID = commentID
+	publishComment(r.Context(), eventCommentCreated, c.ID)
+	notifyCommentAuthor(r.Context(), parentIDInt, store.NotifyReply, c.UserID, c.ID)
+	recordMentions(r.Context(), c.ID, c.UserID, c.Comment)
+	checkBadges(c.UserID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(replies)
+	writeJSON(w, map[string]int{"comment_id": c.ID})
 }
 
-func getCommentSummary(w http.ResponseWriter, r *http.Request) {
-    urlParam := r.URL.Query().Get("url")
-    decodedURL, err := url.QueryUnescape(urlParam)
-    if err != nil {
-        http.Error(w, "Error decoding URL", http.StatusBadRequest)
-        log.Println(err)
-        return
-    }
-
-    // Check if summary exists in database
-    var existingSummary string
-    err = db.QueryRow("SELECT summary FROM summaries WHERE url = ?", decodedURL).Scan(&existingSummary)
-    if err == nil {
-        log.Println("Returning cached summary")
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{"summary": existingSummary})
-        return
-    }
-
-    // Retrieve comments from database
-    query := `SELECT comment FROM comments WHERE url = ?`
-    rows, err := db.Query(query, decodedURL)
-    if err != nil {
-        http.Error(w, "Database error", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-    defer rows.Close()
-
-    var comments []string
-    for rows.Next() {
-        var comment string
-        if err := rows.Scan(&comment); err != nil {
-            http.Error(w, "Error scanning results", http.StatusInternalServerError)
-            log.Println(err)
-            return
-        }
-        comments = append(comments, comment)
-    }
-
-    requestBody, err := json.Marshal(map[string][]string{"messages": comments})
-    if err != nil {
-        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-
-    summaryURL := "http://localhost:6000/summarize"
-    resp, err := http.Post(summaryURL, "application/json", bytes.NewBuffer(requestBody))
-    if err != nil {
-        http.Error(w, "Error calling summarize service", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-    defer resp.Body.Close()
-
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        http.Error(w, "Error reading summarize response", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-
-    var summaryResponse map[string]string
-    if err := json.Unmarshal(body, &summaryResponse); err != nil {
-        http.Error(w, "Error decoding summarize response", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-
-    summary := summaryResponse["summary"]
-
-    // Store the new summary in the database
-    _, err = db.Exec("INSERT INTO summaries (url, summary) VALUES (?, ?)", decodedURL, summary)
-    if err != nil {
-        log.Println("Error storing summary in database:", err)
-    }
-
-    w.Header().Set("Content-Type", "application/json")
-    w.Write(body)
-}
+// commentLike toggles a like or dislike. The status in the response tells the
+// client what happened: 1 added, 2 like removed, 3 dislike removed,
+// 4 like changed to dislike, 5 dislike changed to like. It predates
+// reactions and is kept for older clients; see putVote.
+func commentLike(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		CommentID int  `json:"comment_id"`
+		IsLike    bool `json:"is_like"`
+	}
 
-// Post a comment
-func postComment(w http.ResponseWriter, r *http.Request) {
-    var c Comment
-
-    
-    if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-        http.Error(w, "Invalid JSON", http.StatusBadRequest)
-        return
-    }
-
-    // Set the query for inserting the new comment
-    query := `
-        INSERT INTO comments (url, parent_id, user_id, username, profile_pic, comment, sentiment_score) 
-        VALUES (?, ?, ?, ?, ?, ?, ?);
-    `
-    
-    result, err := db.Exec(query, c.URL, c.ParentID, c.UserID, c.Username, c.ProfilePic, c.Comment, c.SentimentScore)
-    if err != nil {
-        http.Error(w, "Database insert error", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-
-    
-    commentID, err := result.LastInsertId()
-    if err != nil {
-        http.Error(w, "Failed to retrieve comment ID", http.StatusInternalServerError)
-        log.Println(err)
-        return
-    }
-
-    
-    c.ID = int(commentID)
-    
-    var count int
-    db.QueryRow("SELECT COUNT(*) FROM comments WHERE url = ?", c.URL).Scan(&count)
-    if count%5 == 0 {
-        go updateSummary(c.URL)
-    }
-
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]int{"comment_id": c.ID})
-}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		log.Println("invalid structure")
+		return
+	}
 
-func updateSummary(url string) {
-    rows, err := db.Query("SELECT comment FROM comments WHERE url = ?", url)
-    if err != nil {
-        log.Println("Error fetching comments for summary update:", err)
-        return
-    }
-    defer rows.Close()
-
-    var comments []string
-    for rows.Next() {
-        var comment string
-        if err := rows.Scan(&comment); err != nil {
-            log.Println("Error scanning results:", err)
-            return
-        }
-        comments = append(comments, comment)
-    }
-
-    if len(comments) == 0 {
-        return
-    }
-
-    requestBody, _ := json.Marshal(map[string][]string{"messages": comments})
-    resp, err := http.Post("http://localhost:6000/summarize", "application/json", bytes.NewBuffer(requestBody))
-    if err != nil {
-        log.Println("Error calling summarize service:", err)
-        return
-    }
-    defer resp.Body.Close()
-
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        log.Println("Error reading summarize response:", err)
-        return
-    }
-
-    _, err = db.Exec("INSERT INTO summaries (url, summary) VALUES (?, ?) ON CONFLICT(url) DO UPDATE SET summary = ?", url, string(body), string(body))
-    if err != nil {
-        log.Println("Error updating summary table:", err)
-    }
-}
+	ctx := r.Context()
+	userID := viewerID(r)
+	mine, err := repo.Reactions.Mine(ctx, request.CommentID, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
 
-// Post a reply
-func postReply(w http.ResponseWriter, r *http.Request) {
-    log.Println("triggered post")
-
-    var c Comment
-    vars := mux.Vars(r)
-    parentID := vars["parent_id"]
-
-    
-    if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-        http.Error(w, "Invalid JSON", http.StatusBadRequest)
-        return
-    }
-
-    log.Println("reached1")
-
-    
-    parentIDInt, err := strconv.Atoi(parentID)
-    if err != nil {
-        log.Println("Invalid parent ID:", parentID)
-        http.Error(w, "Invalid parent ID", http.StatusBadRequest)
-        return
-    }
-
-    
-    query := `
-        INSERT INTO comments (url, parent_id, user_id, username, profile_pic, comment, sentiment_score) 
-        VALUES (?, ?, ?, ?, ?, ?, ?);
-    `
-    result, err := db.Exec(query, c.URL, parentIDInt, c.UserID, c.Username, c.ProfilePic, c.Comment, c.SentimentScore)
-    if err != nil {
-        log.Println("Error executing query:", err)
-        http.Error(w, "Database insert error", http.StatusInternalServerError)
-        return
-    }
-
-    
-    commentID, err := result.LastInsertId()
-    if err != nil {
-        log.Println("Error retrieving last insert ID:", err)
-        http.Error(w, "Failed to retrieve comment ID", http.StatusInternalServerError)
-        return
-    }
-
-    c.ID = int(commentID) 
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]int{"comment_id": c.ID})
-}
+	kind, other := store.ReactionLike, store.ReactionDislike
+	if !request.IsLike {
+		kind, other = other, kind
+		if !slices.Contains(mine, kind) && !hasKarma(w, r, cfg.Karma.MinToDownvote, "downvote") {
+			return
+		}
+	}
 
-func commentLike(w http.ResponseWriter, r *http.Request) {
-    var request struct {
-        UserID    int  `json:"user_id"`
-        CommentID int  `json:"comment_id"`
-        IsLike    bool `json:"is_like"` 
-    }
-
-    
-    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-        http.Error(w, "Invalid JSON", http.StatusBadRequest)
-        log.Println("invalid structure")
-        return
-    }
-
-    
-    var existingLikeID int
-    var existingIsLike bool
-    err := db.QueryRow("SELECT id, is_like FROM comment_likes WHERE comment_id = ? AND user_id = ?", request.CommentID, request.UserID).Scan(&existingLikeID, &existingIsLike)
-
-    if err == sql.ErrNoRows {
-        
-        query := `INSERT INTO comment_likes (comment_id, user_id, is_like) VALUES (?, ?, ?)`
-        _, err := db.Exec(query, request.CommentID, request.UserID, request.IsLike)
-        if err != nil {
-            http.Error(w, "Error inserting like/dislike", http.StatusInternalServerError)
-            log.Println(err)
-            return
-        }
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{"status": "1"}) 
-    } else if err == nil {
-        if existingIsLike && request.IsLike {
-            query := `DELETE FROM comment_likes WHERE comment_id = ? AND user_id = ?`
-            _, err := db.Exec(query, request.CommentID, request.UserID)
-            if err != nil {
-                http.Error(w, "Error removing like", http.StatusInternalServerError)
-                log.Println(err)
-                return
-            }
-           
-            w.Header().Set("Content-Type", "application/json")
-            json.NewEncoder(w).Encode(map[string]string{"status": "2"})
-            return
-        }
-        if !existingIsLike && !request.IsLike {
-          
-            query := `DELETE FROM comment_likes WHERE comment_id = ? AND user_id = ?`
-            _, err := db.Exec(query, request.CommentID, request.UserID)
-            if err != nil {
-                http.Error(w, "Error removing like", http.StatusInternalServerError)
-                log.Println(err)
-                return
-            }
-            w.Header().Set("Content-Type", "application/json")
-            json.NewEncoder(w).Encode(map[string]string{"status": "3"})
-            return
-        }
-        if existingIsLike && !request.IsLike {
-           
-            query := `UPDATE comment_likes SET is_like = ? WHERE comment_id = ? AND user_id = ?`
-            _, err := db.Exec(query, request.IsLike, request.CommentID, request.UserID)
-            if err != nil {
-                http.Error(w, "Error updating like", http.StatusInternalServerError)
-                log.Println(err)
-                return
-            }
-            w.Header().Set("Content-Type", "application/json")
-            json.NewEncoder(w).Encode(map[string]string{"status": "4"})
-            return
-        }
-        if !existingIsLike && request.IsLike {
-           
-            query := `UPDATE comment_likes SET is_like = ? WHERE comment_id = ? AND user_id = ?`
-            _, err := db.Exec(query, request.IsLike, request.CommentID, request.UserID)
-            if err != nil {
-                http.Error(w, "Error updating like", http.StatusInternalServerError)
-                log.Println(err)
-                return
-            }
-            w.Header().Set("Content-Type", "application/json")
-            json.NewEncoder(w).Encode(map[string]string{"status": "5"})
-            return
-        }
-    } else {
-        http.Error(w, "Database error", http.StatusInternalServerError)
-        log.Println(err)
-    }
-}
+	var status string
+	switch {
+	case slices.Contains(mine, kind):
+		_, err = setVote(ctx, request.CommentID, userID, "")
+		status = "3"
+		if request.IsLike {
+			status = "2"
+		}
+	case slices.Contains(mine, other):
+		_, err = setVote(ctx, request.CommentID, userID, kind)
+		status = "5"
+		if !request.IsLike {
+			status = "4"
+		}
+	default:
+		_, err = setVote(ctx, request.CommentID, userID, kind)
+		status = "1"
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving like", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
 
+	writeJSON(w, map[string]string{"status": status})
+}
@@ -0,0 +1,449 @@
+// Package markdown renders the Markdown subset allowed in comments: bold,
+// italics, code, links, block quotes and lists. Everything else, including
+// raw HTML, is shown as text, and the output passes through Sanitize, so it
+// is safe to insert into any page.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxQuoteDepth stops "> > > ..." from nesting without bound.
+	maxQuoteDepth = 4
+	// maxInlineDepth bounds emphasis nested inside emphasis and links.
+	maxInlineDepth = 8
+	maxURLLength   = 2048
+)
+
+var (
+	bulletItem  = regexp.MustCompile(`^ {0,3}[-*+][ \t]+`)
+	orderedItem = regexp.MustCompile(`^ {0,3}([0-9]{1,9})[.)][ \t]+`)
+	quoteLine   = regexp.MustCompile(`^ {0,3}> ?`)
+	fenceLine   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+)
+
+// Render converts a comment body to sanitized HTML.
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0)
+	return Sanitize(b.String())
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// startsBlock reports whether line begins something other than paragraph
+// text, which ends the paragraph before it.
+func startsBlock(line string, depth int) bool {
+	return fenceLine.MatchString(line) || bulletItem.MatchString(line) || orderedItem.MatchString(line) ||
+		(depth < maxQuoteDepth && quoteLine.MatchString(line))
+}
+
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case fenceLine.MatchString(line):
+			fence := fenceLine.FindStringSubmatch(line)[1]
+			var code []string
+			i++
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // the closing fence, if any
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case depth < maxQuoteDepth && quoteLine.MatchString(line):
+			var quoted []string
+			for i < len(lines) && quoteLine.MatchString(lines[i]) {
+				quoted = append(quoted, quoteLine.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, depth+1)
+			b.WriteString("</blockquote>\n")
+
+		case bulletItem.MatchString(line), orderedItem.MatchString(line):
+			i = renderList(b, lines, i, depth)
+
+		default:
+			var para []string
+			for i < len(lines) && !isBlank(lines[i]) && (len(para) == 0 || !startsBlock(lines[i], depth)) {
+				para = append(para, strings.TrimSpace(lines[i]))
+				i++
+			}
+			b.WriteString("<p>")
+			renderLines(b, para)
+			b.WriteString("</p>\n")
+		}
+	}
+}
+
+// renderList writes the list starting at lines[i] and returns the index of
+// the first line after it. Items hold inline text only; a following line
+// that starts no other block continues the current item.
+func renderList(b *strings.Builder, lines []string, i, depth int) int {
+	marker := bulletItem
+	if !bulletItem.MatchString(lines[i]) {
+		marker = orderedItem
+	}
+
+	if marker == orderedItem {
+		start, _ := strconv.Atoi(orderedItem.FindStringSubmatch(lines[i])[1])
+		if start == 1 {
+			b.WriteString("<ol>\n")
+		} else {
+			b.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for i < len(lines) && marker.MatchString(lines[i]) {
+		item := []string{strings.TrimSpace(marker.ReplaceAllString(lines[i], ""))}
+		i++
+		for i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i], depth) {
+			item = append(item, strings.TrimSpace(lines[i]))
+			i++
+		}
+		b.WriteString("<li>")
+		renderLines(b, item)
+		b.WriteString("</li>\n")
+
+		// A blank line between items keeps the list going.
+		if i+1 < len(lines) && isBlank(lines[i]) && marker.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+
+	if marker == orderedItem {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// renderLines writes lines as inline text separated by line breaks, the way
+// comment authors expect a newline to look.
+func renderLines(b *strings.Builder, lines []string) {
+	for j, line := range lines {
+		if j > 0 {
+			b.WriteString("<br>\n")
+		}
+		renderInline(b, line, 0, true)
+	}
+}
+
+// inline renders one run of inline text. Searches for closing delimiters
+// only move forward, so a delimiter that found no match once cannot find
+// one later in the same run; remembering that keeps rendering linear.
+type inline struct {
+	b         *strings.Builder
+	s         string
+	depth     int
+	links     bool
+	unmatched map[string]bool
+}
+
+func renderInline(b *strings.Builder, s string, depth int, links bool) {
+	in := inline{b: b, s: s, depth: depth, links: links, unmatched: make(map[string]bool)}
+	in.render()
+}
+
+func (in *inline) render() {
+	s := in.s
+	text := 0 // start of the pending plain text
+	flush := func(end int) {
+		in.b.WriteString(html.EscapeString(s[text:end]))
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		var n int
+		switch {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			flush(i)
+			in.b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			n = 2
+		case c == '`':
+			n = in.codeSpan(i, flush)
+		case c == '*' || c == '_':
+			n = in.emphasis(i, flush)
+		case c == '[' && in.links:
+			n = in.link(i, flush)
+		case (c == 'h' || c == 'H') && in.links:
+			n = in.autolink(i, flush)
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		i += n
+		text = i
+	}
+	flush(len(s))
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+// codeSpan handles `code` at s[i] and returns how many bytes it consumed, or
+// 0 if the backticks are literal.
+func (in *inline) codeSpan(i int, flush func(int)) int {
+	s := in.s
+	run := i
+	for run < len(s) && s[run] == '`' {
+		run++
+	}
+	ticks := s[i:run]
+	if in.unmatched[ticks] {
+		return in.literal(i, ticks, flush)
+	}
+	for j := run; j < len(s); {
+		k := strings.Index(s[j:], ticks)
+		if k < 0 {
+			break
+		}
+		k += j
+		end := k + len(ticks)
+		if end < len(s) && s[end] == '`' {
+			// A longer run does not close this one.
+			for end < len(s) && s[end] == '`' {
+				end++
+			}
+			j = end
+			continue
+		}
+		code := s[run:k]
+		if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		flush(i)
+		in.b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return end - i
+	}
+	in.unmatched[ticks] = true
+	return in.literal(i, ticks, flush)
+}
+
+// literal writes a delimiter run that opens nothing as text, so that its
+// parts are not tried again one by one.
+func (in *inline) literal(i int, run string, flush func(int)) int {
+	flush(i)
+	in.b.WriteString(html.EscapeString(run))
+	return len(run)
+}
+
+// emphasis handles *em*, _em_, **strong** and __strong__ at s[i].
+func (in *inline) emphasis(i int, flush func(int)) int {
+	s := in.s
+	c := s[i]
+	delim := string(c)
+	if i+1 < len(s) && s[i+1] == c {
+		delim += delim
+	}
+	if in.depth >= maxInlineDepth || in.unmatched[delim] {
+		return in.literal(i, delim, flush)
+	}
+
+	open := i + len(delim)
+	if open >= len(s) || isSpaceAt(s, open) {
+		return 0
+	}
+	// Underscores inside words, as in snake_case, are literal.
+	if c == '_' && isWordBefore(s, i) {
+		return 0
+	}
+
+	for j := open + 1; j <= len(s)-len(delim); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == '`' {
+			// Delimiters inside a code span do not count.
+			if end := strings.IndexByte(s[j+1:], '`'); end >= 0 {
+				j += end + 1
+				continue
+			}
+		}
+		if s[j] != c {
+			continue
+		}
+		// A run of delimiters closes with its last one or two characters,
+		// so "*a **b***" is em around strong. A single delimiter skips
+		// even runs, which belong to a strong inside it.
+		run := j
+		for run < len(s) && s[run] == c {
+			run++
+		}
+		start := j
+		j = run - 1
+		if isSpaceBefore(s, start) || (len(delim) == 1 && (run-start)%2 == 0) || run-start < len(delim) {
+			continue
+		}
+		after := run
+		if c == '_' && isWordAt(s, after) {
+			continue
+		}
+		closeAt := run - len(delim)
+		if closeAt <= open {
+			continue
+		}
+
+		tag := "em"
+		if len(delim) == 2 {
+			tag = "strong"
+		}
+		flush(i)
+		in.b.WriteString("<" + tag + ">")
+		renderInline(in.b, s[open:closeAt], in.depth+1, in.links)
+		in.b.WriteString("</" + tag + ">")
+		return after - i
+	}
+	in.unmatched[delim] = true
+	return in.literal(i, delim, flush)
+}
+
+// link handles [text](url) at s[i]. Links to anything but http(s) and
+// mailto URLs keep their text and lose the link.
+func (in *inline) link(i int, flush func(int)) int {
+	s := in.s
+	if in.unmatched["]"] {
+		return 0
+	}
+	nest := 0
+	closeAt := -1
+	for j := i + 1; j < len(s) && closeAt < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			nest++
+		case ']':
+			if nest == 0 {
+				closeAt = j
+			}
+			nest--
+		}
+	}
+	if closeAt < 0 {
+		in.unmatched["]"] = true
+		return 0
+	}
+	if closeAt+1 >= len(s) || s[closeAt+1] != '(' {
+		return 0
+	}
+	if in.unmatched[")"] || strings.IndexByte(s[closeAt+2:], ')') < 0 {
+		in.unmatched[")"] = true
+		return 0
+	}
+	end, depth := -1, 0
+	limit := min(len(s), closeAt+2+maxURLLength)
+	for j := closeAt + 2; j < limit && end < 0 && s[j] != ' ' && s[j] != '\t'; j++ {
+		switch s[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = j
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return 0
+	}
+	href := strings.TrimSpace(s[closeAt+2 : end])
+	if href == "" {
+		return 0
+	}
+	href = strings.TrimSuffix(strings.TrimPrefix(href, "<"), ">")
+
+	flush(i)
+	if safeURL(href) {
+		in.b.WriteString(`<a href="` + html.EscapeString(href) + `">`)
+		renderInline(in.b, s[i+1:closeAt], in.depth+1, false)
+		in.b.WriteString("</a>")
+	} else {
+		renderInline(in.b, s[i+1:closeAt], in.depth+1, false)
+	}
+	return end + 1 - i
+}
+
+// autolink turns a bare http(s) URL at s[i] into a link. Trailing
+// punctuation is left out, as is a closing parenthesis without an opening
+// one, so "(see https://example.com)." links just the URL.
+func (in *inline) autolink(i int, flush func(int)) int {
+	s := in.s
+	lower := strings.ToLower(s[i:min(i+8, len(s))])
+	if !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "http://") {
+		return 0
+	}
+	if isWordBefore(s, i) {
+		return 0
+	}
+	end := i
+	for end < len(s) && !isSpaceAt(s, end) && s[end] != '<' && s[end] != '>' && s[end] != '"' {
+		end++
+	}
+	for end > i {
+		last := s[end-1]
+		if strings.IndexByte(".,:;!?'*_", last) >= 0 ||
+			(last == ')' && strings.Count(s[i:end], "(") < strings.Count(s[i:end], ")")) {
+			end--
+			continue
+		}
+		break
+	}
+	href := s[i:end]
+	if !safeURL(href) || !strings.Contains(href[strings.Index(href, "//")+2:], ".") {
+		return 0
+	}
+	flush(i)
+	in.b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(href) + "</a>")
+	return end - i
+}
+
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+func isSpaceBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsSpace(r)
+}
+
+func isWordBefore(s string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordAt(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,37 @@
+package markdown
+
+import "testing"
+
+const link = ` rel="nofollow ugc noopener" target="_blank"`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"emphasis", "**bold** and *it* and _it_", "<p><strong>bold</strong> and <em>it</em> and <em>it</em></p>\n"},
+		{"unclosed emphasis", "**unclosed", "<p>**unclosed</p>\n"},
+		{"code span", "`a<b`", "<p><code>a&lt;b</code></p>\n"},
+		{"fenced code", "```\ncode <b>\n```", "<pre><code>code &lt;b&gt;</code></pre>\n"},
+		{"link", "[x](https://e.com)", `<p><a href="https://e.com"` + link + ">x</a></p>\n"},
+		{"mailto link", "[m](mailto:a@b.c)", `<p><a href="mailto:a@b.c"` + link + ">m</a></p>\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"autolink", "https://e.com/x", `<p><a href="https://e.com/x"` + link + ">https://e.com/x</a></p>\n"},
+		{"raw HTML is text", "<b>raw</b>", "<p>&lt;b&gt;raw&lt;/b&gt;</p>\n"},
+		{"script is text", "<script>alert(1)</script>hi", "<p>&lt;script&gt;alert(1)&lt;/script&gt;hi</p>\n"},
+		{"bullet list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"quote", "> quote\n> more", "<blockquote>\n<p>quote<br>\nmore</p>\n</blockquote>\n"},
+		{"quote depth is capped", "> > > > > > deep",
+			"<blockquote>\n<blockquote>\n<blockquote>\n<blockquote>\n<p>&gt; &gt; deep</p>\n</blockquote>\n</blockquote>\n</blockquote>\n</blockquote>\n"},
+		{"paragraphs", "a\nb\n\nc", "<p>a<br>\nb</p>\n<p>c</p>\n"},
+		{"CRLF", "a\r\nb", "<p>a<br>\nb</p>\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
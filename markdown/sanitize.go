@@ -0,0 +1,120 @@
+package markdown
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowed maps each tag Sanitize keeps to the attributes it may carry.
+var allowed = map[string][]string{
+	"p":          nil,
+	"br":         nil,
+	"strong":     nil,
+	"em":         nil,
+	"code":       nil,
+	"pre":        nil,
+	"blockquote": nil,
+	"ul":         nil,
+	"ol":         {"start"},
+	"li":         nil,
+	"a":          {"href"},
+}
+
+// safeURL reports whether href may be the target of a link.
+func safeURL(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+// Sanitize reduces an HTML fragment to the tags and attributes Render
+// produces. Other tags are dropped with their text kept, except script and
+// style, which are dropped entirely; unsafe links lose their href; unclosed
+// tags are closed. Links get rel="nofollow ugc noopener" and open in a new
+// tab, since comments are shown on pages their authors do not control.
+func Sanitize(fragment string) string {
+	var b strings.Builder
+	var open []string
+	skip := 0 // depth inside script or style
+
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(html.EscapeString(tok.Data))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if tok.Data == "script" || tok.Data == "style" {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			attrs, ok := allowed[tok.Data]
+			if !ok || skip > 0 {
+				continue
+			}
+			b.WriteString("<" + tok.Data)
+			for _, a := range tok.Attr {
+				if a.Namespace != "" || !slices.Contains(attrs, a.Key) {
+					continue
+				}
+				if a.Key == "href" && !safeURL(a.Val) {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			if tok.Data == "a" {
+				b.WriteString(` rel="nofollow ugc noopener" target="_blank"`)
+			}
+			b.WriteString(">")
+			if tok.Data != "br" {
+				open = append(open, tok.Data)
+			}
+
+		case html.EndTagToken:
+			if tok.Data == "script" || tok.Data == "style" {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			// Close everything opened since the matching start tag; end
+			// tags that match nothing are dropped.
+			k := len(open) - 1
+			for k >= 0 && open[k] != tok.Data {
+				k--
+			}
+			if k < 0 {
+				continue
+			}
+			for len(open) > k {
+				b.WriteString("</" + open[len(open)-1] + ">")
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for len(open) > 0 {
+		b.WriteString("</" + open[len(open)-1] + ">")
+		open = open[:len(open)-1]
+	}
+	return b.String()
+}
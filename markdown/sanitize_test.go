@@ -0,0 +1,48 @@
+package markdown
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"allowed tags", "<p><strong>a</strong> <em>b</em></p>", "<p><strong>a</strong> <em>b</em></p>"},
+		{"event handlers", `<p onclick="x">t</p>`, "<p>t</p>"},
+		{"unsafe href", `<a href="javascript:x" onclick="y">l</a>`, "<a" + link + ">l</a>"},
+		{"link target is forced", `<a href="https://e.com" target="_self">x</a>`, `<a href="https://e.com"` + link + ">x</a>"},
+		{"host-less http link", `<a href="https:///x">x</a>`, "<a" + link + ">x</a>"},
+		{"unclosed tags", `<ol start="3"><li>x`, `<ol start="3"><li>x</li></ol>`},
+		{"style is dropped with its text", "<style>p{}</style><em>e</em>", "<em>e</em>"},
+		{"script is dropped with its text", "<script>alert(1)</script>ok", "ok"},
+		{"unknown tags keep their text", "<div><span>text</span></div>", "text"},
+		{"img", "<img src=x onerror=alert(1)>", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		href string
+		want bool
+	}{
+		{"https://e.com/x", true},
+		{"HTTP://e.com", true},
+		{"mailto:a@b.c", true},
+		{"javascript:alert(1)", false},
+		{"data:text/html,x", false},
+		{"/relative", false},
+		{"https://", false},
+		{"mailto:", false},
+	}
+	for _, tt := range tests {
+		if got := safeURL(tt.href); got != tt.want {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.href, got, tt.want)
+		}
+	}
+}
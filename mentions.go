@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"myproject/store"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// recordMentions stores the users mentioned in a new or edited comment and
+// notifies the ones it did not mention before.
+func recordMentions(ctx context.Context, commentID, authorID int, body string) {
+	added, err := repo.Mentions.Set(ctx, commentID, body)
+	if err != nil {
+		log.Println("Error recording mentions:", err)
+		return
+	}
+	for _, userID := range added {
+		notify(ctx, userID, store.NotifyMention, authorID, commentID)
+	}
+}
+
+// suggestUsers serves GET /users/suggest?prefix= for @mention autocomplete.
+// For a logged-in caller, the users they are connected with come first.
+func suggestUsers(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "@")
+	if prefix == "" {
+		http.Error(w, "prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSuggestLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var connected []int
+	if userID := viewerID(r); userID != 0 {
+		var err error
+		connected, err = graph.ConnectedWithin(r.Context(), userID, 1)
+		if err != nil {
+			// Suggestions still work without the graph, just unranked.
+			log.Println("Error fetching connected users:", err)
+		}
+	}
+
+	suggestions, err := repo.Users.Suggest(r.Context(), prefix, connected, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, suggestions)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"myproject/store"
+)
+
+const migrateUsage = `usage: myproject [-config file] migrate <command>
+
+commands:
+  up         apply all pending migrations
+  down [n]   revert the last n applied migrations (default 1)
+  status     list migrations and when they were applied`
+
+// runMigrate implements the "migrate" subcommand and returns the process
+// exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp(ctx, db)
+		for _, m := range applied {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "invalid step count %q\n", args[1])
+				return 2
+			}
+			steps = n
+		}
+		reverted, err := store.MigrateDown(ctx, db, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "status":
+		states, err := store.MigrationStatus(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		tw.Flush()
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+)
+
+// notify adds a notification for userID unless the actor is notifying
+// themselves. Failures are logged; they never fail the action itself.
+func notify(ctx context.Context, userID int, kind string, actorID, commentID int) {
+	if userID == 0 || userID == actorID {
+		return
+	}
+	if err := repo.Notifications.Add(ctx, userID, kind, actorID, commentID); err != nil {
+		log.Printf("Error adding %s notification for user %d: %v", kind, userID, err)
+	}
+}
+
+// notifyCommentAuthor notifies the author of commentID. Deleted comments
+// have no author and are skipped.
+func notifyCommentAuthor(ctx context.Context, commentID int, kind string, actorID, subjectID int) {
+	c, err := repo.Comments.Get(ctx, commentID, 0)
+	if err != nil {
+		log.Println("Error loading comment for notification:", err)
+		return
+	}
+	notify(ctx, c.UserID, kind, actorID, subjectID)
+}
+
+// getNotifications lists the caller's notifications, newest first.
+// unread=true leaves out the ones already read. limit and cursor paginate
+// like the comment listings.
+func getNotifications(w http.ResponseWriter, r *http.Request) {
+	unreadOnly, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	notifications, next, err := repo.Notifications.List(r.Context(), viewerID(r), unreadOnly, page)
+	writePage(w, notifications, next, err)
+}
+
+// getUnreadCount is cheap enough for the extension to poll for its badge.
+func getUnreadCount(w http.ResponseWriter, r *http.Request) {
+	count, err := repo.Notifications.UnreadCount(r.Context(), viewerID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, map[string]int{"unread": count})
+}
+
+func markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	err = repo.Notifications.MarkRead(r.Context(), viewerID(r), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func markAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	marked, err := repo.Notifications.MarkAllRead(r.Context(), viewerID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, map[string]int{"marked": marked})
+}
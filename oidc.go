@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+
+	"myproject/store"
+)
+
+// oidcLoginTTL bounds how long a user may take at the provider's login page.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie ties a login's state to the browser that started it, so
+// that nobody can finish their own login in someone else's browser.
+const oidcStateCookie = "oidc_state"
+
+// oidcProvider wraps one configured identity provider. Discovery happens on
+// first use so the server can start while a provider is unreachable.
+type oidcProvider struct {
+	cfg OIDCProviderConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcProviders is keyed by provider name; see setupOIDC.
+var oidcProviders map[string]*oidcProvider
+
+func setupOIDC() {
+	oidcProviders = make(map[string]*oidcProvider)
+	for _, p := range cfg.Auth.OIDCProviders {
+		oidcProviders[p.Name] = &oidcProvider{cfg: p}
+	}
+}
+
+func (p *oidcProvider) discover() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// The provider keeps this context for fetching signing keys later, so it
+	// must outlive the request that triggered discovery.
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: 10 * time.Second})
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes := append([]string{oidc.ScopeOpenID}, p.cfg.Scopes...)
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       slices.Compact(scopes),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+func listOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(cfg.Auth.OIDCProviders))
+	for _, p := range cfg.Auth.OIDCProviders {
+		names = append(names, p.Name)
+	}
+	writeJSON(w, map[string][]string{"providers": names})
+}
+
+// oidcStart redirects the browser to the provider's authorization endpoint
+// using PKCE. When the request is authenticated the resulting identity is
+// linked to the current user instead of logging in. The state is also set in
+// a cookie that the callback checks, so clients starting the flow with fetch
+// must let the browser store it.
+func oidcStart(w http.ResponseWriter, r *http.Request) {
+	p, ok := oidcProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo != "" && !slices.Contains(p.cfg.ReturnURLs, returnTo) {
+		http.Error(w, "return_to is not allowed", http.StatusBadRequest)
+		return
+	}
+
+	oauthCfg, _, err := p.discover()
+	if err != nil {
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		log.Println("OIDC discovery failed:", err)
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	login := store.OIDCLogin{
+		State:        state,
+		Provider:     p.cfg.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       viewerID(r),
+		ReturnTo:     returnTo,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if err := repo.Identities.StartLogin(r.Context(), login); err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(p.cfg.RedirectURL, "https://"),
+		// Lax still sends the cookie on the provider's top-level redirect
+		// back to the callback.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := oauthCfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+
+	// Clients that start the flow with fetch (e.g. to link an account while
+	// sending their bearer token) cannot read a redirect, so hand them the URL.
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, map[string]string{"authorization_url": authURL})
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// idClaims are the ID token claims used to create or describe a user.
+type idClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Picture           string `json:"picture"`
+}
+
+// oidcCallback completes the authorization-code flow, resolves the identity
+// to a local user and issues a session.
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "Login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Login was not started in this browser", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc/", MaxAge: -1, HttpOnly: true})
+
+	login, err := repo.Identities.TakeLogin(ctx, state)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Unknown or expired login state", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	p, ok := oidcProviders[mux.Vars(r)["provider"]]
+	if !ok || p.cfg.Name != login.Provider {
+		http.Error(w, "Provider mismatch", http.StatusBadRequest)
+		return
+	}
+	oauthCfg, idVerifier, err := p.discover()
+	if err != nil {
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		log.Println("OIDC discovery failed:", err)
+		return
+	}
+
+	token, err := oauthCfg.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		http.Error(w, "Error exchanging authorization code", http.StatusUnauthorized)
+		log.Println(err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Provider returned no ID token", http.StatusBadGateway)
+		return
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		log.Println(err)
+		return
+	}
+	if idToken.Nonce != login.Nonce {
+		http.Error(w, "Invalid ID token nonce", http.StatusUnauthorized)
+		return
+	}
+
+	var claims idClaims
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Invalid ID token claims", http.StatusUnauthorized)
+		log.Println(err)
+		return
+	}
+
+	user, err := resolveIdentity(ctx, login, claims)
+	if errors.Is(err, errIdentityTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error linking identity", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if login.ReturnTo == "" {
+		issueSession(w, r, user)
+		return
+	}
+
+	sessionToken, expiresAt, err := createSession(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	// The token travels in the fragment so it never reaches server logs.
+	fragment := url.Values{
+		"token":      {sessionToken},
+		"user_id":    {fmt.Sprint(user.ID)},
+		"public_id":  {user.PublicID},
+		"expires_at": {expiresAt.UTC().Format(time.RFC3339)},
+	}
+	http.Redirect(w, r, login.ReturnTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+var errIdentityTaken = errors.New("identity is already linked to another user")
+
+// resolveIdentity returns the user linked to the provider identity, linking
+// it to the logged-in user that started the flow or to a new account.
+func resolveIdentity(ctx context.Context, login store.OIDCLogin, claims idClaims) (store.User, error) {
+	linkedID, err := repo.Identities.UserID(ctx, login.Provider, claims.Subject)
+	switch {
+	case err == nil:
+		if login.UserID != 0 && login.UserID != linkedID {
+			return store.User{}, errIdentityTaken
+		}
+		return repo.Users.ByID(ctx, linkedID)
+	case !errors.Is(err, store.ErrNotFound):
+		return store.User{}, err
+	}
+
+	var user store.User
+	if login.UserID != 0 {
+		user, err = repo.Users.ByID(ctx, login.UserID)
+	} else {
+		user, err = createOIDCUser(ctx, claims)
+	}
+	if err != nil {
+		return store.User{}, err
+	}
+
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	if err := repo.Identities.Link(ctx, login.Provider, claims.Subject, user.ID, email); err != nil {
+		return store.User{}, err
+	}
+	return user, nil
+}
+
+// createOIDCUser makes a passwordless account named after the identity's
+// preferred username, email or display name, adding a suffix on collision.
+// register refuses the name, so only the identity's owner can add a password,
+// through setPassword. Owners of an existing account log in to it first,
+// claiming it if need be, so that the identity is linked instead.
+func createOIDCUser(ctx context.Context, claims idClaims) (store.User, error) {
+	base := claims.PreferredUsername
+	if base == "" && claims.EmailVerified {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if base == "" {
+		base = claims.Name
+	}
+	base = strings.TrimSpace(base)
+	if base == "" || len(base) > 56 {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		user := store.User{Username: name}
+		err := repo.Users.Create(ctx, &user)
+		if errors.Is(err, store.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return user, err
+		}
+
+		// Seed the profile from the provider; the user can edit it later.
+		update := store.ProfileUpdate{DisplayName: &claims.Name}
+		if u, err := url.Parse(claims.Picture); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			update.AvatarURL = &claims.Picture
+		}
+		return user, repo.Profiles.Update(ctx, user.ID, update)
+	}
+	return store.User{}, fmt.Errorf("no free username for %q", base)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, a signing key and a
+// token endpoint that checks PKCE. Tests play the browser by calling
+// authorize with the URL that oidcStart redirected to.
+type mockIssuer struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key, codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize approves the authorization request in authURL for an identity
+// with claims and returns the callback query the provider would redirect
+// with. A "nonce" claim overrides the one from the request.
+func (m *mockIssuer) authorize(authURL string, claims map[string]any) url.Values {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("authorization request without S256 PKCE: %s", authURL)
+	}
+
+	all := map[string]any{"nonce": q.Get("nonce")}
+	for k, v := range claims {
+		all[k] = v
+	}
+	code, err := randomToken()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: all}
+	m.mu.Unlock()
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	grant, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss": m.URL,
+		"aud": "client",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	writeJSON(w, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.sign(claims),
+	})
+}
+
+func (m *mockIssuer) sign(claims map[string]any) string {
+	m.t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func setupTestOIDC(t *testing.T) *mockIssuer {
+	t.Helper()
+	setupTestRepo(t)
+	m := newMockIssuer(t)
+	cfg.Auth.OIDCProviders = []OIDCProviderConfig{{
+		Name:         "mock",
+		Issuer:       m.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+		ReturnURLs:   []string{"https://ext.example/done"},
+	}}
+	setupOIDC()
+	return m
+}
+
+// oidcLogin is one run through the flow in a simulated browser.
+type oidcLogin struct {
+	authURL string
+	cookie  *http.Cookie
+}
+
+func startOIDC(t *testing.T, token, query string) oidcLogin {
+	t.Helper()
+	rec := serve(t, "GET", "/auth/oidc/mock/start"+query, token, "")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("start: status = %d: %s", rec.Code, rec.Body)
+	}
+	var login oidcLogin
+	login.authURL = rec.Header().Get("Location")
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			login.cookie = c
+		}
+	}
+	if login.cookie == nil || !login.cookie.HttpOnly {
+		t.Fatalf("start did not set an HttpOnly %s cookie", oidcStateCookie)
+	}
+	return login
+}
+
+func callbackOIDC(t *testing.T, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/auth/oidc/mock/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	testRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func callbackUserID(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	var resp struct {
+		UserID int    `json:"user_id"`
+		Token  string `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" {
+		t.Fatal("callback returned no session token")
+	}
+	return resp.UserID
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := setupTestOIDC(t)
+	ctx := context.Background()
+	claims := map[string]any{"sub": "alice-sub", "preferred_username": "alice", "name": "Alice"}
+
+	login := startOIDC(t, "", "")
+	rec := callbackOIDC(t, m.authorize(login.authURL, claims), login.cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status = %d: %s", rec.Code, rec.Body)
+	}
+	userID := callbackUserID(t, rec)
+
+	user, err := repo.Users.ByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || user.PasswordHash != "" {
+		t.Errorf("created user %+v, want passwordless alice", user)
+	}
+
+	// The same identity logs in to the same account.
+	login = startOIDC(t, "", "")
+	rec = callbackOIDC(t, m.authorize(login.authURL, claims), login.cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second callback: status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := callbackUserID(t, rec); got != userID {
+		t.Errorf("second login got user %d, want %d", got, userID)
+	}
+
+	// Nobody can claim the OIDC account's name with a password.
+	if rec := serve(t, "POST", "/register", "", `{"username": "alice", "password": "correct horse"}`); rec.Code != http.StatusConflict {
+		t.Errorf("register alice: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	m := setupTestOIDC(t)
+	claims := map[string]any{"sub": "mallory-sub"}
+
+	tests := []struct {
+		name string
+		// run starts a login and returns the callback query and cookie a
+		// browser would present.
+		run        func(t *testing.T) (url.Values, *http.Cookie)
+		wantStatus int
+	}{
+		{
+			name: "no state cookie",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				return m.authorize(login.authURL, claims), nil
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// The attacker's own login, finished in a victim's browser that
+			// started a different one.
+			name: "cookie from another login",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				victim := startOIDC(t, "", "")
+				attacker := startOIDC(t, "", "")
+				return m.authorize(attacker.authURL, claims), victim.cookie
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown state",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				query := m.authorize(login.authURL, claims)
+				query.Set("state", "forged")
+				return query, &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "state used twice",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				query := m.authorize(login.authURL, claims)
+				if rec := callbackOIDC(t, query, login.cookie); rec.Code != http.StatusOK {
+					t.Fatalf("first callback: status = %d: %s", rec.Code, rec.Body)
+				}
+				return m.authorize(login.authURL, claims), login.cookie
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "wrong nonce",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				return m.authorize(login.authURL, map[string]any{"sub": "mallory-sub", "nonce": "replayed"}), login.cookie
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown code",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				query := m.authorize(login.authURL, claims)
+				query.Set("code", "forged")
+				return query, login.cookie
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "provider error",
+			run: func(t *testing.T) (url.Values, *http.Cookie) {
+				login := startOIDC(t, "", "")
+				return url.Values{"error": {"access_denied"}}, login.cookie
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, cookie := tt.run(t)
+			if rec := callbackOIDC(t, query, cookie); rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	m := setupTestOIDC(t)
+	bob, bobToken := createTestUser(t, "bob", "")
+	_, carolToken := createTestUser(t, "carol", "")
+
+	login := startOIDC(t, bobToken, "")
+	rec := callbackOIDC(t, m.authorize(login.authURL, map[string]any{"sub": "bob-sub"}), login.cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := callbackUserID(t, rec); got != bob.ID {
+		t.Errorf("linked to user %d, want %d", got, bob.ID)
+	}
+
+	// Another user cannot take over the linked identity.
+	login = startOIDC(t, carolToken, "")
+	rec = callbackOIDC(t, m.authorize(login.authURL, map[string]any{"sub": "bob-sub"}), login.cookie)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("relink: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestOIDCReturnTo(t *testing.T) {
+	m := setupTestOIDC(t)
+
+	if rec := serve(t, "GET", "/auth/oidc/mock/start?return_to=https://evil.example/", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unlisted return_to: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	login := startOIDC(t, "", "?return_to="+url.QueryEscape("https://ext.example/done"))
+	rec := callbackOIDC(t, m.authorize(login.authURL, map[string]any{"sub": "dave-sub"}), login.cookie)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: status = %d: %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	base, fragment, _ := strings.Cut(location, "#")
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != "https://ext.example/done" || values.Get("token") == "" {
+		t.Errorf("redirected to %q, want the return URL with a token in the fragment", location)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+
+	"myproject/store"
+)
+
+// lookupUser resolves a {id} path segment, which may be either the numeric
+// user ID or the public ID.
+func lookupUser(r *http.Request) (store.User, error) {
+	return userByRef(r.Context(), mux.Vars(r)["id"])
+}
+
+// userByRef finds a user by numeric ID or public ID.
+func userByRef(ctx context.Context, ref string) (store.User, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		return repo.Users.ByID(ctx, n)
+	}
+	return repo.Users.ByPublicID(ctx, ref)
+}
+
+func getProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := lookupUser(r)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	profile, err := repo.Profiles.Get(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	writeJSON(w, profile)
+}
+
+// updateProfile applies a partial update to the caller's own profile.
+func updateProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := lookupUser(r)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if user.ID != viewerID(r) {
+		http.Error(w, "You can only edit your own profile", http.StatusForbidden)
+		return
+	}
+
+	var update store.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if msg := validateProfileUpdate(&update); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := repo.Profiles.Update(r.Context(), user.ID, update); err != nil {
+		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	profile, err := repo.Profiles.Get(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, profile)
+}
+
+// validateProfileUpdate checks the fields being set and canonicalizes the
+// locale tag. It returns a message for the client, or "" if u is valid.
+func validateProfileUpdate(u *store.ProfileUpdate) string {
+	if u.DisplayName != nil && utf8.RuneCountInString(*u.DisplayName) > 64 {
+		return "display_name must be at most 64 characters"
+	}
+	if u.Bio != nil && utf8.RuneCountInString(*u.Bio) > 500 {
+		return "bio must be at most 500 characters"
+	}
+	if u.AvatarURL != nil && *u.AvatarURL != "" {
+		parsed, err := url.Parse(*u.AvatarURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return "avatar_url must be an http(s) URL"
+		}
+	}
+	if u.Locale != nil && *u.Locale != "" {
+		tag, err := language.Parse(*u.Locale)
+		if err != nil {
+			return "locale must be a BCP 47 language tag"
+		}
+		canonical := tag.String()
+		u.Locale = &canonical
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+)
+
+// reactionKind returns the configured reaction named name.
+func reactionKind(name string) (ReactionKind, bool) {
+	i := slices.IndexFunc(cfg.Reactions.Kinds, func(k ReactionKind) bool { return k.Name == name })
+	if i < 0 {
+		return ReactionKind{}, false
+	}
+	return cfg.Reactions.Kinds[i], true
+}
+
+// reactionReplaces lists the kinds a new reaction of kind removes: the other
+// vote for likes and dislikes, and everything when users get one reaction.
+func reactionReplaces(kind string) []string {
+	if cfg.Reactions.OnePerUser {
+		var all []string
+		for _, k := range cfg.Reactions.Kinds {
+			all = append(all, k.Name)
+		}
+		return all
+	}
+	switch kind {
+	case store.ReactionLike:
+		return []string{store.ReactionDislike}
+	case store.ReactionDislike:
+		return []string{store.ReactionLike}
+	}
+	return nil
+}
+
+// addReaction records a reaction and tells everyone who follows the comment.
+// Likes and dislikes go through setVote.
+func addReaction(ctx context.Context, commentID, userID int, kind string) (bool, error) {
+	if kind == store.ReactionLike || kind == store.ReactionDislike {
+		return setVote(ctx, commentID, userID, kind)
+	}
+	added, err := repo.Reactions.Add(ctx, commentID, userID, kind, reactionReplaces(kind))
+	if err != nil {
+		return false, err
+	}
+	if added {
+		publishComment(ctx, eventCommentVotes, commentID)
+	}
+	return added, nil
+}
+
+func removeReaction(ctx context.Context, commentID, userID int, kind string) (bool, error) {
+	removed, err := repo.Reactions.Remove(ctx, commentID, userID, kind)
+	if err != nil {
+		return false, err
+	}
+	if removed {
+		publishComment(ctx, eventCommentVotes, commentID)
+	}
+	return removed, nil
+}
+
+// Vote states accepted by putVote.
+const (
+	voteUp   = "up"
+	voteDown = "down"
+	voteNone = "none"
+)
+
+var voteKinds = map[string]string{
+	voteUp:   store.ReactionLike,
+	voteDown: store.ReactionDislike,
+	voteNone: "",
+}
+
+// setVote sets the user's vote to kind, or clears it for "".
+func setVote(ctx context.Context, commentID, userID int, kind string) (bool, error) {
+	var replaces []string
+	if kind != "" {
+		replaces = reactionReplaces(kind)
+	}
+	changed, err := repo.Reactions.Vote(ctx, commentID, userID, kind, replaces)
+	if err != nil {
+		return false, err
+	}
+	if changed && kind == store.ReactionLike {
+		notifyCommentAuthor(ctx, commentID, store.NotifyLike, userID, commentID)
+		checkAuthorBadges(commentID)
+	}
+	if changed {
+		publishComment(ctx, eventCommentVotes, commentID)
+	}
+	return changed, nil
+}
+
+// putVote serves PUT /comments/{id}/vote with {"vote": "up" | "down" |
+// "none"}. Unlike /comment_like it states the outcome rather than toggling,
+// so a retried request leaves the vote as intended.
+func putVote(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Vote string `json:"vote"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	kind, ok := voteKinds[request.Vote]
+	if !ok {
+		http.Error(w, `vote must be "up", "down" or "none"`, http.StatusBadRequest)
+		return
+	}
+	if kind == store.ReactionDislike && !hasKarma(w, r, cfg.Karma.MinToDownvote, "downvote") {
+		return
+	}
+
+	ctx := r.Context()
+	changed, err := setVote(ctx, id, viewerID(r), kind)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving vote", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	c, err := repo.Comments.Get(ctx, id, viewerID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	vote := voteNone
+	if c.LikeStatus != nil {
+		vote = voteDown
+		if *c.LikeStatus {
+			vote = voteUp
+		}
+	}
+	writeJSON(w, map[string]any{
+		"comment_id":    c.ID,
+		"vote":          vote,
+		"changed":       changed,
+		"like_count":    c.LikeCount,
+		"dislike_count": c.DislikeCount,
+	})
+}
+
+// listReactions serves GET /reactions, the kinds clients may offer.
+func listReactions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"kinds":        cfg.Reactions.Kinds,
+		"one_per_user": cfg.Reactions.OnePerUser,
+	})
+}
+
+// putReaction serves PUT and DELETE /comments/{id}/reactions/{kind}. Both are
+// idempotent and answer with the comment's reactions afterwards.
+func putReaction(w http.ResponseWriter, r *http.Request) {
+	id, ok := commentIDVar(w, r)
+	if !ok {
+		return
+	}
+	kind, ok := reactionKind(mux.Vars(r)["kind"])
+	if !ok {
+		http.Error(w, "Unknown reaction", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPut && kind.Name == store.ReactionDislike &&
+		!hasKarma(w, r, cfg.Karma.MinToDownvote, "downvote") {
+		return
+	}
+
+	ctx := r.Context()
+	var err error
+	if r.Method == http.MethodDelete {
+		_, err = removeReaction(ctx, id, viewerID(r), kind.Name)
+	} else {
+		_, err = addReaction(ctx, id, viewerID(r), kind.Name)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving reaction", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	c, err := repo.Comments.Get(ctx, id, viewerID(r))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"comment_id":   c.ID,
+		"reactions":    c.Reactions,
+		"my_reactions": c.MyReactions,
+	})
+}
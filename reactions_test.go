@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPutReaction(t *testing.T) {
+	tests := []struct {
+		onePerUser bool
+		// steps are "PUT kind" or "DELETE kind", each followed by the
+		// caller's reactions afterwards in order.
+		steps [][2]string
+	}{
+		{false, [][2]string{
+			{"PUT love", "love"},
+			{"PUT laugh", "laugh love"},
+			{"PUT like", "laugh like love"},
+			{"PUT dislike", "dislike laugh love"},
+			{"PUT dislike", "dislike laugh love"},
+			{"DELETE love", "dislike laugh"},
+			{"DELETE love", "dislike laugh"},
+		}},
+		{true, [][2]string{
+			{"PUT love", "love"},
+			{"PUT laugh", "laugh"},
+			{"PUT like", "like"},
+			{"PUT dislike", "dislike"},
+			{"PUT wow", "wow"},
+			{"DELETE wow", ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("one per user %v", tt.onePerUser), func(t *testing.T) {
+			setupTestRepo(t)
+			cfg.Reactions.OnePerUser = tt.onePerUser
+			author, _ := createTestUser(t, "author", "")
+			_, token := createTestUser(t, "reader", "")
+			id := createTestComment(t, author)
+
+			for _, step := range tt.steps {
+				var method, kind string
+				fmt.Sscan(step[0], &method, &kind)
+				rec := serve(t, method, fmt.Sprintf("/comments/%d/reactions/%s", id, kind), token, "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: status = %d: %s", step[0], rec.Code, rec.Body)
+				}
+				var body struct {
+					MyReactions []string `json:"my_reactions"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Join(body.MyReactions, " "); got != step[1] {
+					t.Errorf("%s: my_reactions = %q, want %q", step[0], got, step[1])
+				}
+			}
+		})
+	}
+
+	setupTestRepo(t)
+	author, _ := createTestUser(t, "author", "")
+	_, token := createTestUser(t, "reader", "")
+	id := createTestComment(t, author)
+	failures := []struct {
+		target string
+		want   int
+	}{
+		{fmt.Sprintf("/comments/%d/reactions/shrug", id), http.StatusBadRequest},
+		{fmt.Sprintf("/comments/%d/reactions/love", id+1), http.StatusNotFound},
+	}
+	for _, e := range failures {
+		if rec := serve(t, "PUT", e.target, token, ""); rec.Code != e.want {
+			t.Errorf("PUT %s: status = %d, want %d", e.target, rec.Code, e.want)
+		}
+	}
+}
+
+// TestCommentLike checks that /comment_like still answers with the status
+// values older clients expect.
+func TestCommentLike(t *testing.T) {
+	setupTestRepo(t)
+	author, _ := createTestUser(t, "author", "")
+	_, token := createTestUser(t, "reader", "")
+	id := createTestComment(t, author)
+
+	steps := []struct {
+		name       string
+		isLike     bool
+		wantStatus string
+		wantLikes  int
+		wantDown   int
+	}{
+		{"like", true, "1", 1, 0},
+		{"like again removes it", true, "2", 0, 0},
+		{"dislike", false, "1", 0, 1},
+		{"dislike again removes it", false, "3", 0, 0},
+		{"like after removing", true, "1", 1, 0},
+		{"switch to dislike", false, "4", 0, 1},
+		{"switch to like", true, "5", 1, 0},
+	}
+	for _, st := range steps {
+		t.Run(st.name, func(t *testing.T) {
+			rec := serve(t, "POST", "/comment_like", token, fmt.Sprintf(`{"comment_id": %d, "is_like": %v}`, id, st.isLike))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Status string `json:"status"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != st.wantStatus {
+				t.Errorf("status = %q, want %q", body.Status, st.wantStatus)
+			}
+			c, err := repo.Comments.Get(context.Background(), id, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.LikeCount != st.wantLikes || c.DislikeCount != st.wantDown {
+				t.Errorf("counts = %d likes, %d dislikes; want %d, %d", c.LikeCount, c.DislikeCount, st.wantLikes, st.wantDown)
+			}
+		})
+	}
+
+	minToDownvote := 10.0
+	cfg.Karma.MinToDownvote = &minToDownvote
+	failures := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"invalid JSON", `{`, http.StatusBadRequest},
+		{"missing comment", fmt.Sprintf(`{"comment_id": %d, "is_like": true}`, id+1), http.StatusNotFound},
+		{"downvote without karma", fmt.Sprintf(`{"comment_id": %d, "is_like": false}`, id), http.StatusForbidden},
+	}
+	for _, e := range failures {
+		if rec := serve(t, "POST", "/comment_like", token, e.body); rec.Code != e.want {
+			t.Errorf("%s: status = %d, want %d", e.name, rec.Code, e.want)
+		}
+	}
+}
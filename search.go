@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"myproject/store"
+)
+
+// searchComments serves GET /search?q=. url (with an optional scope as in
+// /comments), user_id (numeric or public ID) and since (RFC 3339 or
+// YYYY-MM-DD) narrow the results. Results are ranked by relevance and
+// paginated with limit and cursor like the other listings.
+func searchComments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := store.SearchQuery{Text: strings.TrimSpace(query.Get("q"))}
+	if q.Text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("url") != "" {
+		pageURL, ok := requestPageURL(w, r)
+		if !ok {
+			return
+		}
+		filter, err := scopeFilter(pageURL, query.Get("scope"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Pages = &filter
+	}
+
+	if ref := query.Get("user_id"); ref != "" {
+		user, err := userByRef(r.Context(), ref)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		q.AuthorID = user.ID
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, since)
+		}
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		q.Since = t
+	}
+
+	// Search only ranks by relevance, so a sort parameter is not accepted.
+	if query.Get("sort") != "" {
+		http.Error(w, "search results cannot be sorted", http.StatusBadRequest)
+		return
+	}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	results, next, err := repo.Comments.Search(r.Context(), q, viewerID(r), page)
+	if errors.Is(err, store.ErrSearchUnavailable) {
+		http.Error(w, "Search is not available on this server", http.StatusServiceUnavailable)
+		return
+	}
+	writePage(w, results, next, err)
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// Badge is an achievement users earn once what it counts for them reaches
+// Threshold. Badges are never taken away, even if the count later drops.
+type Badge struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Threshold   int    `json:"threshold"`
+	// count selects user_id and n, the number the threshold applies to, for
+	// each user in the JSON array :users.
+	count string
+}
+
+// commentHost is the host of a canonical comment URL, which always has a
+// path after it.
+const commentHost = `substr(c.url, instr(c.url, '://') + 3,
+	instr(substr(c.url, instr(c.url, '://') + 3), '/') - 1)`
+
+// Badges are the badges users can earn, in the order profiles list them.
+var Badges = []Badge{
+	{
+		Name:        "first_comment",
+		Title:       "First words",
+		Description: "Posted a first comment",
+		Threshold:   1,
+		count: `
+			SELECT c.user_id, COUNT(*) AS n FROM comments c
+			WHERE c.user_id IN (SELECT value FROM json_each(:users))
+			GROUP BY c.user_id`,
+	},
+	{
+		Name:        "liked_100",
+		Title:       "Crowd favourite",
+		Description: "Received 100 likes from other users",
+		Threshold:   100,
+		count: `
+			SELECT c.user_id, COUNT(*) AS n FROM comments c
+			JOIN comment_reactions r ON r.comment_id = c.id AND r.kind = 'like' AND r.user_id != c.user_id
+			WHERE c.user_id IN (SELECT value FROM json_each(:users))
+			GROUP BY c.user_id`,
+	},
+	{
+		// Connecting through someone's comment links both users.
+		Name:        "connections_10",
+		Title:       "Networker",
+		Description: "Connected with 10 users",
+		Threshold:   10,
+		count: `
+			SELECT user_id, COUNT(DISTINCT other) AS n FROM (
+				SELECT cn.user_id, c.user_id AS other FROM connection cn JOIN comments c ON c.id = cn.comment_id
+				UNION ALL
+				SELECT c.user_id, cn.user_id FROM connection cn JOIN comments c ON c.id = cn.comment_id
+			)
+			WHERE user_id != other AND user_id IN (SELECT value FROM json_each(:users))
+			GROUP BY user_id`,
+	},
+	{
+		Name:        "domains_50",
+		Title:       "Globetrotter",
+		Description: "Commented on 50 different sites",
+		Threshold:   50,
+		count: `
+			SELECT c.user_id, COUNT(DISTINCT ` + commentHost + `) AS n FROM comments c
+			WHERE c.user_id IN (SELECT value FROM json_each(:users)) AND c.url IS NOT NULL
+			GROUP BY c.user_id`,
+	},
+}
+
+// UserBadge is a badge a user has earned.
+type UserBadge struct {
+	Badge
+	AwardedAt string `json:"awarded_at"`
+}
+
+// Award is a badge newly given to a user.
+type Award struct {
+	UserID int
+	Badge  string
+}
+
+type BadgeStore interface {
+	// Award evaluates every badge for userIDs and records the ones they
+	// have newly earned.
+	Award(ctx context.Context, userIDs []int) ([]Award, error)
+	// List returns the badges userID has earned, in the order of Badges.
+	List(ctx context.Context, userID int) ([]UserBadge, error)
+}
+
+type sqliteBadges struct {
+	db *sql.DB
+}
+
+func (s *sqliteBadges) Award(ctx context.Context, userIDs []int) ([]Award, error) {
+	return awardBadges(ctx, s.db, userIDs)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// awardBadges only awards users with an account; comments by authors who
+// never registered do not earn them badges.
+func awardBadges(ctx context.Context, db queryer, userIDs []int) ([]Award, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	users, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var awards []Award
+	for _, b := range Badges {
+		rows, err := db.QueryContext(ctx, `
+			INSERT INTO user_badges (user_id, badge, awarded_at)
+			SELECT counts.user_id, :badge, :now
+			FROM (`+b.count+`) counts JOIN users u ON u.id = counts.user_id
+			WHERE counts.n >= :threshold
+			ON CONFLICT DO NOTHING
+			RETURNING user_id`,
+			sql.Named("users", string(users)), sql.Named("badge", b.Name),
+			sql.Named("threshold", b.Threshold), sql.Named("now", now()))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			a := Award{Badge: b.Name}
+			if err := rows.Scan(&a.UserID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			awards = append(awards, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return awards, nil
+}
+
+func (s *sqliteBadges) List(ctx context.Context, userID int) ([]UserBadge, error) {
+	return listBadges(ctx, s.db, userID)
+}
+
+func listBadges(ctx context.Context, db queryer, userID int) ([]UserBadge, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT badge, awarded_at FROM user_badges WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	earned := make(map[string]string)
+	for rows.Next() {
+		var name, awardedAt string
+		if err := rows.Scan(&name, &awardedAt); err != nil {
+			return nil, err
+		}
+		earned[name] = awardedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Badges that are no longer defined are left out.
+	badges := []UserBadge{}
+	for _, b := range Badges {
+		if awardedAt, ok := earned[b.Name]; ok {
+			badges = append(badges, UserBadge{Badge: b, AwardedAt: awardedAt})
+		}
+	}
+	return badges, nil
+}
+
+// backfillBadges awards the badges existing users have already earned.
+// Authors of legacy comments who never had an account get none.
+func backfillBadges(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM users`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = awardBadges(ctx, tx, ids)
+	return err
+}
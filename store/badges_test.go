@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestAwardBadges(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	// Legacy comments can name authors who never made an account.
+	const noAccount = 133663637
+	for i := range 50 {
+		url := fmt.Sprintf("https://site%d.example/", i)
+		createComment(t, s, Comment{UserID: alice.ID, Username: "alice", URL: url})
+		createComment(t, s, Comment{UserID: noAccount, Username: "no account", URL: url})
+	}
+
+	awards, err := s.Badges.Award(ctx, []int{alice.ID, bob.ID, noAccount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Award{{alice.ID, "first_comment"}, {alice.ID, "domains_50"}}
+	if !slices.Equal(awards, want) {
+		t.Errorf("Award = %+v, want %+v", awards, want)
+	}
+	if again, err := s.Badges.Award(ctx, []int{alice.ID}); err != nil || len(again) != 0 {
+		t.Errorf("second Award = %+v, %v; want nothing new", again, err)
+	}
+
+	var orphans int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user_badges WHERE user_id = ?`, noAccount).Scan(&orphans); err != nil {
+		t.Fatal(err)
+	}
+	if orphans != 0 {
+		t.Errorf("user without an account has %d badges", orphans)
+	}
+
+	tests := []struct {
+		user User
+		want []string
+	}{
+		{alice, []string{"first_comment", "domains_50"}},
+		{bob, nil},
+	}
+	for _, tt := range tests {
+		badges, err := s.Badges.List(ctx, tt.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, b := range badges {
+			names = append(names, b.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s's badges = %v, want %v", tt.user.Username, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"myproject/markdown"
+)
+
+// Comment is a comment or reply enriched with counts and the viewer's state.
+type Comment struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"user_id"`
+	UserPublicID string `json:"user_public_id,omitempty"`
+	ParentID     *int   `json:"parent_id,omitempty"`
+	Comment      string `json:"comment"`
+	// CommentHTML is Comment rendered as sanitized Markdown, cached when the
+	// comment is written.
+	CommentHTML    string  `json:"comment_html"`
+	CreatedAt      string  `json:"created_at"`
+	EditedAt       *string `json:"edited_at"`
+	RevisionCount  int     `json:"revision_count"`
+	Username       string  `json:"username"`
+	ProfilePic     string  `json:"profile_pic"`
+	SentimentScore int     `json:"sentiment_score"`
+	ReplyCount     int     `json:"reply_count"`
+	LikeCount      int     `json:"like_count"`
+	DislikeCount   int     `json:"dislike_count"`
+	LikeStatus     *bool   `json:"like_status"`
+	// Reactions counts each kind of reaction, likes and dislikes included;
+	// MyReactions lists the viewer's.
+	Reactions   map[string]int `json:"reactions"`
+	MyReactions []string       `json:"my_reactions"`
+	// AuthorKarma is the author's karma, rounded; see KarmaHalfLife.
+	AuthorKarma int    `json:"author_karma"`
+	ConStatus   *bool  `json:"con_status"`
+	URL         string `json:"url,omitempty"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// DeletedBody replaces the text of a soft-deleted comment.
+const DeletedBody = "[deleted]"
+
+// CommentStore reads and writes comments. viewerID is the user whose
+// like_status and con_status are reported; pass 0 for an anonymous viewer.
+type CommentStore interface {
+	// ListTopLevel returns a page of the comments on the pages matching
+	// filter that are not replies, and the cursor of the next page or "" on
+	// the last page.
+	ListTopLevel(ctx context.Context, filter PageFilter, viewerID int, page Page) ([]Comment, string, error)
+	// ListReplies returns a page of the direct replies to parentID.
+	ListReplies(ctx context.Context, parentID, viewerID int, page Page) ([]Comment, string, error)
+	// ListByAuthors returns a page of the comments on url written by one of
+	// authorIDs.
+	ListByAuthors(ctx context.Context, url string, viewerID int, authorIDs []int, page Page) ([]Comment, string, error)
+	// Thread returns rootID with its replies nested up to depth levels, each
+	// level paginated like ListReplies.
+	Thread(ctx context.Context, rootID, viewerID, depth int, page Page) (*ThreadNode, error)
+	// Search returns a page of the comments matching q, best match first.
+	// page.Sort is ignored.
+	Search(ctx context.Context, q SearchQuery, viewerID int, page Page) ([]SearchResult, string, error)
+	// Get returns ErrNotFound if there is no comment with that ID.
+	Get(ctx context.Context, id, viewerID int) (Comment, error)
+	// Create inserts c and returns its new ID.
+	Create(ctx context.Context, c *Comment) (int, error)
+	// Edit replaces the body of a comment written by authorID, keeping the
+	// old body as a revision. It returns ErrForbidden for other users.
+	Edit(ctx context.Context, id, authorID int, body string, sentimentScore *int) error
+	// Revisions returns the earlier bodies of a comment, oldest first.
+	Revisions(ctx context.Context, id int) ([]Revision, error)
+	// SoftDelete tombstones a comment: its body becomes DeletedBody, its
+	// author and revisions are hidden, and its replies stay in place. It
+	// returns ErrForbidden unless byAuthor wrote the comment; a byAuthor of 0
+	// skips that check for moderators.
+	SoftDelete(ctx context.Context, id, byAuthor int) error
+	// Purge permanently removes a comment, all replies below it and their
+	// reactions, connections, revisions, mentions and notifications. It
+	// returns the number of comments removed.
+	Purge(ctx context.Context, id int) (int, error)
+	CountByURL(ctx context.Context, url string) (int, error)
+	// SiteStats counts the comments on the pages matching filter and lists
+	// the topPages most discussed of them.
+	SiteStats(ctx context.Context, filter PageFilter, topPages int) (SiteStats, error)
+	// URLs returns every distinct page URL that has comments.
+	URLs(ctx context.Context) ([]string, error)
+	// RewriteURL moves every comment on from to to, dropping the cached
+	// summaries of both pages and merging their trending scores. It returns
+	// the number of comments moved.
+	RewriteURL(ctx context.Context, from, to string) (int, error)
+	// BodiesByURL returns the text of every comment on url that has not
+	// been deleted.
+	BodiesByURL(ctx context.Context, url string) ([]string, error)
+}
+
+// commentProjection selects every Comment field. Queries built on it bind
+// the :viewer parameter. Author name and avatar come from the current profile;
+// the copies stored on the comment are only a fallback for authors without a
+// users row. Deleted comments report no author. Columns are named so that
+// queries can wrap the projection and order by them; see queryPage.
+const commentProjection = `
+	SELECT c.id, c.url,
+	       CASE WHEN c.deleted_at IS NULL THEN c.user_id ELSE 0 END AS user_id,
+	       CASE WHEN c.deleted_at IS NULL THEN u.public_id END AS user_public_id,
+	       c.parent_id, c.comment, c.comment_html, c.created_at, c.edited_at,
+	       (SELECT COUNT(*) FROM comment_revisions cr WHERE cr.comment_id = c.id) AS revision_count,
+	       CASE WHEN c.deleted_at IS NULL THEN COALESCE(p.display_name, u.username, c.username) ELSE '` + DeletedBody + `' END AS username,
+	       CASE WHEN c.deleted_at IS NULL THEN COALESCE(p.avatar_url, c.profile_pic) END AS profile_pic,
+	       c.sentiment_score,
+	       (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count,
+	       (SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = c.id AND cr.kind = 'like') AS like_count,
+	       (SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = c.id AND cr.kind = 'dislike') AS dislike_count,
+	       (SELECT cr.kind = 'like' FROM comment_reactions cr
+	        WHERE cr.comment_id = c.id AND cr.user_id = :viewer AND cr.kind IN ('like', 'dislike')) AS like_status,
+	       (SELECT json_group_object(kind, n) FROM (
+	           SELECT cr.kind, COUNT(*) AS n FROM comment_reactions cr WHERE cr.comment_id = c.id GROUP BY cr.kind
+	       )) AS reactions,
+	       (SELECT json_group_array(kind) FROM (
+	           SELECT cr.kind FROM comment_reactions cr WHERE cr.comment_id = c.id AND cr.user_id = :viewer ORDER BY cr.kind
+	       )) AS my_reactions,
+	       CASE WHEN c.deleted_at IS NULL THEN COALESCE(u.karma, 0) ELSE 0 END AS author_karma,
+	       EXISTS (
+	           SELECT 1 FROM connection cn WHERE cn.user_id = :viewer AND cn.comment_id = c.id
+	       ) AS con_status,
+	       c.deleted_at IS NOT NULL AS deleted
+	FROM comments c
+	LEFT JOIN users u ON u.id = c.user_id
+	LEFT JOIN profiles p ON p.user_id = c.user_id`
+
+// scanComment reads one commentProjection row into c. extra receives any
+// columns the query selects after the projection.
+func scanComment(rows *sql.Rows, c *Comment, extra ...any) error {
+	var publicID, profilePic sql.NullString
+	var reactions, myReactions string
+	var authorKarma float64
+	dest := []any{
+		&c.ID, &c.URL, &c.UserID, &publicID, &c.ParentID, &c.Comment, &c.CommentHTML, &c.CreatedAt, &c.EditedAt, &c.RevisionCount,
+		&c.Username, &profilePic,
+		&c.SentimentScore, &c.ReplyCount, &c.LikeCount, &c.DislikeCount, &c.LikeStatus, &reactions, &myReactions,
+		&authorKarma, &c.ConStatus, &c.Deleted,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(reactions), &c.Reactions); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(myReactions), &c.MyReactions); err != nil {
+		return err
+	}
+	c.UserPublicID = publicID.String
+	c.ProfilePic = profilePic.String
+	c.AuthorKarma = int(math.Round(KarmaAt(authorKarma, time.Now())))
+	return nil
+}
+
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := scanComment(rows, &c); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+type sqliteComments struct {
+	db *sql.DB
+}
+
+func (s *sqliteComments) query(ctx context.Context, where string, args ...any) ([]Comment, error) {
+	rows, err := s.db.QueryContext(ctx, commentProjection+" "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanComments(rows)
+}
+
+// listedComment hides deleted comments from listings unless they still have
+// replies that need a parent to hang from.
+const listedComment = `(c.deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id))`
+
+func (s *sqliteComments) ListTopLevel(ctx context.Context, filter PageFilter, viewerID int, page Page) ([]Comment, string, error) {
+	return s.queryPage(ctx, `WHERE `+filter.where()+` AND c.parent_id IS NULL AND `+listedComment, page,
+		sql.Named("viewer", viewerID), sql.Named("page_url", filter.URL))
+}
+
+func (s *sqliteComments) ListReplies(ctx context.Context, parentID, viewerID int, page Page) ([]Comment, string, error) {
+	return s.queryPage(ctx, `WHERE c.parent_id = :parent AND `+listedComment, page,
+		sql.Named("viewer", viewerID), sql.Named("parent", parentID))
+}
+
+func (s *sqliteComments) ListByAuthors(ctx context.Context, url string, viewerID int, authorIDs []int, page Page) ([]Comment, string, error) {
+	authors, err := json.Marshal(authorIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.queryPage(ctx, `
+		WHERE c.user_id IN (SELECT value FROM json_each(:authors)) AND c.url = :url AND c.deleted_at IS NULL`, page,
+		sql.Named("viewer", viewerID), sql.Named("authors", string(authors)), sql.Named("url", url))
+}
+
+func (s *sqliteComments) Get(ctx context.Context, id, viewerID int) (Comment, error) {
+	comments, err := s.query(ctx, `WHERE c.id = :id`, sql.Named("viewer", viewerID), sql.Named("id", id))
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, ErrNotFound
+	}
+	return comments[0], nil
+}
+
+func (s *sqliteComments) Create(ctx context.Context, c *Comment) (int, error) {
+	var id int
+	c.Comment = cleanBody(c.Comment)
+	c.CommentHTML = markdown.Render(c.Comment)
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO comments (url, parent_id, user_id, username, profile_pic, comment, comment_html, sentiment_score)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.URL, c.ParentID, c.UserID, c.Username, c.ProfilePic, c.Comment, c.CommentHTML, c.SentimentScore)
+		if err != nil {
+			return err
+		}
+		lastID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = int(lastID)
+
+		if err := refreshHot(ctx, tx, id); err != nil {
+			return err
+		}
+		return recordActivity(ctx, tx, c.URL, commentWeight, now())
+	})
+	return id, err
+}
+
+func (s *sqliteComments) CountByURL(ctx context.Context, url string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM comments WHERE url = ? AND deleted_at IS NULL`, url).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteComments) BodiesByURL(ctx context.Context, url string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT comment FROM comments WHERE url = ? AND deleted_at IS NULL`, url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bodies []string
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, rows.Err()
+}
+
+func (s *sqliteComments) Edit(ctx context.Context, id, authorID int, body string, sentimentScore *int) error {
+	body = cleanBody(body)
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var owner int
+		var oldBody string
+		var writtenAt string
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id, comment, COALESCE(edited_at, created_at) FROM comments WHERE id = ? AND deleted_at IS NULL`, id).
+			Scan(&owner, &oldBody, &writtenAt)
+		if err != nil {
+			return notFound(err)
+		}
+		if owner != authorID {
+			return ErrForbidden
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO comment_revisions (comment_id, comment, written_at) VALUES (?, ?, ?)`,
+			id, oldBody, writtenAt); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE comments
+			SET comment = ?, comment_html = ?, sentiment_score = COALESCE(?, sentiment_score),
+			    edited_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			body, markdown.Render(body), sentimentScore, id)
+		return err
+	})
+}
+
+// cleanBody removes C0 control characters other than tab and newlines from a
+// comment body. Besides being invisible, \x02 and \x03 would be mistaken
+// for the match markers of search snippets.
+func cleanBody(body string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, body)
+}
+
+// Revision is an earlier body of a comment and the period it was shown.
+type Revision struct {
+	Comment    string `json:"comment"`
+	WrittenAt  string `json:"written_at"`
+	ReplacedAt string `json:"replaced_at"`
+}
+
+func (s *sqliteComments) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT comment, written_at, replaced_at FROM comment_revisions
+		WHERE comment_id = ? ORDER BY id ASC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.Comment, &rev.WrittenAt, &rev.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *sqliteComments) SoftDelete(ctx context.Context, id, byAuthor int) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var owner int
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id FROM comments WHERE id = ? AND deleted_at IS NULL`, id).Scan(&owner)
+		if err != nil {
+			return notFound(err)
+		}
+		if byAuthor != 0 && owner != byAuthor {
+			return ErrForbidden
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE comments SET comment = ?, comment_html = ?, deleted_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			DeletedBody, markdown.Render(DeletedBody), id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM comment_revisions WHERE comment_id = ?`, id); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM comment_mentions WHERE comment_id = ?`, id)
+		return err
+	})
+}
+
+func (s *sqliteComments) Purge(ctx context.Context, id int) (int, error) {
+	var purged int
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS purge_ids (id INTEGER PRIMARY KEY);
+			DELETE FROM purge_ids;`); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO purge_ids (id)
+			WITH RECURSIVE subtree(id) AS (
+				SELECT id FROM comments WHERE id = ?
+				UNION
+				SELECT c.id FROM comments c JOIN subtree s ON c.parent_id = s.id
+			)
+			SELECT id FROM subtree`, id)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		purged = int(n)
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM comment_reactions WHERE comment_id IN (SELECT id FROM purge_ids);
+			DELETE FROM connection WHERE comment_id IN (SELECT id FROM purge_ids);
+			DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM purge_ids);
+			DELETE FROM notifications WHERE comment_id IN (SELECT id FROM purge_ids);
+			DELETE FROM comment_mentions WHERE comment_id IN (SELECT id FROM purge_ids);
+			DELETE FROM comments WHERE id IN (SELECT id FROM purge_ids);
+			DELETE FROM purge_ids;`)
+		return err
+	})
+	return purged, err
+}
+
+func (s *sqliteComments) URLs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT url FROM comments WHERE url IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+func (s *sqliteComments) RewriteURL(ctx context.Context, from, to string) (int, error) {
+	var moved int
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE comments SET url = ? WHERE url = ?`, to, from)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		moved = int(n)
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM summaries WHERE url IN (?, ?)`, from, to); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO page_trending (url, period, log_score)
+			SELECT ?, period, log_score FROM page_trending WHERE url = ? AND true
+			ON CONFLICT (url, period) DO UPDATE SET log_score = log2_add(log_score, excluded.log_score);
+			DELETE FROM page_trending WHERE url = ?`,
+			to, from, from)
+		return err
+	})
+	return moved, err
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEditRevisions(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	id := createComment(t, s, Comment{UserID: alice.ID, Comment: "v1", SentimentScore: 1})
+	created, err := s.Comments.Get(ctx, id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.EditedAt != nil || created.RevisionCount != 0 {
+		t.Errorf("new comment = %+v, want it unedited", created)
+	}
+
+	score := 2
+	if err := s.Comments.Edit(ctx, id, alice.ID, "v2", &score); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := s.Comments.Get(ctx, id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Comments.Edit(ctx, id, alice.ID, "**v3**", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.Comments.Get(ctx, id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Comment != "**v3**" || c.CommentHTML != "<p><strong>v3</strong></p>\n" || c.EditedAt == nil ||
+		c.RevisionCount != 2 || c.SentimentScore != score {
+		t.Errorf("edited comment = %+v", c)
+	}
+
+	// Each revision runs from when its body was written until the next edit.
+	revisions, err := s.Comments.Revisions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Revision{
+		{Comment: "v1", WrittenAt: created.CreatedAt},
+		{Comment: "v2", WrittenAt: *edited.EditedAt},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("Revisions = %+v, want %+v", revisions, want)
+	}
+	for i, rev := range revisions {
+		if rev.Comment != want[i].Comment || rev.WrittenAt != want[i].WrittenAt || rev.ReplacedAt == "" {
+			t.Errorf("revision %d = %+v, want %+v", i, rev, want[i])
+		}
+	}
+
+	if err := s.Comments.Edit(ctx, id+1, alice.ID, "v4", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Edit of unknown comment: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Comments.SoftDelete(ctx, id, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Comments.Edit(ctx, id, alice.ID, "v4", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Edit of deleted comment: err = %v, want ErrNotFound", err)
+	}
+	if revisions, err := s.Comments.Revisions(ctx, id); err != nil || len(revisions) != 0 {
+		t.Errorf("Revisions of deleted comment = %+v, %v; want none", revisions, err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	root := createComment(t, s, Comment{UserID: alice.ID})
+	reply := createComment(t, s, Comment{UserID: bob.ID, ParentID: &root, Comment: "@alice no"})
+	nested := createComment(t, s, Comment{UserID: alice.ID, ParentID: &reply})
+	sibling := createComment(t, s, Comment{UserID: bob.ID})
+	for _, id := range []int{root, reply, nested, sibling} {
+		if _, err := s.Reactions.Vote(ctx, id, bob.ID, ReactionLike, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Connections.Add(ctx, alice.ID, id); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Notifications.Add(ctx, alice.ID, NotifyReply, bob.ID, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Comments.Edit(ctx, nested, alice.ID, "edited", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Mentions.Set(ctx, reply, "@alice no"); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := s.Comments.Purge(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("Purge removed %d comments, want the reply and the reply below it", purged)
+	}
+	for _, table := range []string{"comment_reactions", "connection", "comment_revisions", "notifications", "comment_mentions"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE comment_id IN (?, ?)`, reply, nested).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows of %s left for purged comments", n, table)
+		}
+	}
+	for _, id := range []int{root, sibling} {
+		c, err := s.Comments.Get(ctx, id, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ReplyCount != 0 || c.LikeCount != 1 || c.ConStatus == nil || !*c.ConStatus {
+			t.Errorf("comment %d lost its own data: %+v", id, c)
+		}
+	}
+
+	if _, err := s.Comments.Purge(ctx, reply); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Purge: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	const from, to, other = "https://example.com/?utm_source=x", "https://example.com/", "https://example.com/other"
+	for _, url := range []string{from, from, to, other} {
+		createComment(t, s, Comment{UserID: alice.ID, URL: url})
+	}
+	for _, url := range []string{from, to, other} {
+		if err := s.Summaries.Put(ctx, url, "summary"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := s.Comments.RewriteURL(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2 {
+		t.Errorf("RewriteURL moved %d comments, want 2", moved)
+	}
+	for url, want := range map[string]int{from: 0, to: 3, other: 1} {
+		if n, err := s.Comments.CountByURL(ctx, url); err != nil || n != want {
+			t.Errorf("CountByURL(%q) = %d, %v; want %d", url, n, err, want)
+		}
+	}
+
+	// Both summaries are stale now; other pages keep theirs.
+	for url, want := range map[string]error{from: ErrNotFound, to: ErrNotFound, other: nil} {
+		if _, err := s.Summaries.Get(ctx, url); !errors.Is(err, want) {
+			t.Errorf("summary of %q: err = %v, want %v", url, err, want)
+		}
+	}
+
+	// All three comments now count towards the new page's score.
+	pages, err := s.Trending.Top(ctx, "24h", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0].URL != to || math.Abs(pages[0].Score-3) > 1e-3 || pages[0].CommentCount != 3 {
+		t.Errorf("trending after RewriteURL = %+v, want %q with all three comments first", pages, to)
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM page_trending WHERE url = ?`, from).Scan(&rows); err != nil || rows != 0 {
+		t.Errorf("%d trending rows left for the old URL (%v)", rows, err)
+	}
+}
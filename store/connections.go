@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ConnectionStore records which comments a user connected through, so
+// listings can report con_status.
+type ConnectionStore interface {
+	// Add does nothing if userID already connected through commentID.
+	Add(ctx context.Context, userID, commentID int) error
+	Remove(ctx context.Context, userID, commentID int) error
+	// Connected reports whether either user connected through a comment
+	// written by the other, which is what links them in the social graph.
+	Connected(ctx context.Context, userID1, userID2 int) (bool, error)
+}
+
+type sqliteConnections struct {
+	db *sql.DB
+}
+
+func (s *sqliteConnections) Add(ctx context.Context, userID, commentID int) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO connection (user_id, comment_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, commentID)
+	return err
+}
+
+func (s *sqliteConnections) Remove(ctx context.Context, userID, commentID int) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM connection WHERE user_id = ? AND comment_id = ?`, userID, commentID)
+	return err
+}
+
+func (s *sqliteConnections) Connected(ctx context.Context, userID1, userID2 int) (bool, error) {
+	var connected bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM connection cn JOIN comments c ON c.id = cn.comment_id
+			WHERE (cn.user_id = :a AND c.user_id = :b) OR (cn.user_id = :b AND c.user_id = :a)
+		)`, sql.Named("a", userID1), sql.Named("b", userID2)).Scan(&connected)
+	return connected, err
+}
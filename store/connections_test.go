@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConnections(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	byBob := createComment(t, s, Comment{UserID: bob.ID, Username: "bob"})
+
+	for range 2 {
+		if err := s.Connections.Add(ctx, alice.ID, byBob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM connection`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("connecting twice left %d rows, want 1", rows)
+	}
+
+	tests := []struct {
+		name string
+		a, b int
+		want bool
+	}{
+		{"through the other's comment", alice.ID, bob.ID, true},
+		{"in the other direction", bob.ID, alice.ID, true},
+		{"unrelated", alice.ID, carol.ID, false},
+	}
+	for _, tt := range tests {
+		if got, err := s.Connections.Connected(ctx, tt.a, tt.b); err != nil || got != tt.want {
+			t.Errorf("%s: Connected = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if err := s.Connections.Remove(ctx, alice.ID, byBob); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Connections.Connected(ctx, alice.ID, bob.ID); err != nil || got {
+		t.Errorf("Connected after Remove = %v, %v; want false", got, err)
+	}
+}
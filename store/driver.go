@@ -0,0 +1,66 @@
+package store
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the database/sql driver to open the database with. It is
+// go-sqlite3 plus the SQL functions the store's queries rely on.
+const DriverName = "sqlite3_urlext"
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			funcs := map[string]any{
+				"wilson_lower_bound": wilsonLowerBound,
+				"hot_rank":           hotRank,
+				"log2_add":           log2Add,
+				"karma_vote":         karmaVote,
+			}
+			for name, fn := range funcs {
+				if err := conn.RegisterFunc(name, fn, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// wilsonLowerBound is the lower bound of the 95% Wilson score interval for
+// the share of positive votes. It ranks a comment with 10 likes and 1
+// dislike above one with a single like.
+func wilsonLowerBound(likes, dislikes int64) float64 {
+	n := float64(likes + dislikes)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.96
+	p := float64(likes) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// hotRank orders comments by net votes on a log scale plus age, so that ten
+// times the votes buys a comment 12.5 hours over a newer one. It changes only
+// when votes do, which lets it be stored instead of computed per query.
+func hotRank(netVotes, createdUnix int64) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(netVotes)), 1))
+	switch {
+	case netVotes < 0:
+		order = -order
+	case netVotes == 0:
+		order = 0
+	}
+	return order + float64(createdUnix)/45000
+}
+
+// log2Add returns log2(2^a + 2^b) without overflowing for large a and b.
+func log2Add(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log2(1+math.Exp2(b-a))
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OIDCLogin is an authorization request waiting for its callback.
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	// UserID is set when an already logged-in user is linking a provider.
+	UserID    int
+	ReturnTo  string
+	ExpiresAt time.Time
+}
+
+// IdentityStore links external OIDC identities to local users and tracks
+// in-flight logins.
+type IdentityStore interface {
+	// UserID returns ErrNotFound if the identity is not linked yet.
+	UserID(ctx context.Context, provider, subject string) (int, error)
+	Link(ctx context.Context, provider, subject string, userID int, email string) error
+
+	StartLogin(ctx context.Context, l OIDCLogin) error
+	// TakeLogin removes and returns the login for state. Unknown or expired
+	// states return ErrNotFound, so each state can be used only once.
+	TakeLogin(ctx context.Context, state string) (OIDCLogin, error)
+}
+
+type sqliteIdentities struct {
+	db *sql.DB
+}
+
+func (s *sqliteIdentities) UserID(ctx context.Context, provider, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`,
+		provider, subject).Scan(&userID)
+	return userID, notFound(err)
+}
+
+func (s *sqliteIdentities) Link(ctx context.Context, provider, subject string, userID int, email string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_identities (provider, subject, user_id, email) VALUES (?, ?, ?, NULLIF(?, ''))`,
+		provider, subject, userID, email)
+	return err
+}
+
+func (s *sqliteIdentities) StartLogin(ctx context.Context, l OIDCLogin) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO oidc_logins (state, provider, nonce, code_verifier, user_id, return_to, expires_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, ''), ?)`,
+		l.State, l.Provider, l.Nonce, l.CodeVerifier, l.UserID, l.ReturnTo, l.ExpiresAt.UTC().Truncate(time.Second))
+	return err
+}
+
+func (s *sqliteIdentities) TakeLogin(ctx context.Context, state string) (OIDCLogin, error) {
+	// Drop abandoned logins while we are here.
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at <= ?`, now()); err != nil {
+		return OIDCLogin{}, err
+	}
+
+	var l OIDCLogin
+	var userID sql.NullInt64
+	var returnTo sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM oidc_logins WHERE state = ?
+		RETURNING state, provider, nonce, code_verifier, user_id, return_to, expires_at`,
+		state).Scan(&l.State, &l.Provider, &l.Nonce, &l.CodeVerifier, &userID, &returnTo, &l.ExpiresAt)
+	l.UserID = int(userID.Int64)
+	l.ReturnTo = returnTo.String
+	return l, notFound(err)
+}
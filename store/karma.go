@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Karma is the sum of the votes a user's comments received. Each vote is
+// weighted by the voter's own karma when it was cast and halves in value
+// every KarmaHalfLife.
+//
+// Since every vote decays at the same rate, users.karma and
+// comment_reactions.karma hold values scaled to karmaEpoch: a vote cast at t
+// is stored as weight * 2^((t - karmaEpoch) / KarmaHalfLife). Sums of such
+// values never need rewriting as time passes, triggers keep users.karma
+// equal to the sum of the votes on the user's comments, and ordering users
+// by the stored value orders them by current karma. KarmaAt converts back.
+const KarmaHalfLife = 180 * 24 * time.Hour
+
+var karmaEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxVoteWeight caps how much a single vote can count, however reputable
+// the voter.
+const maxVoteWeight = 3
+
+// karmaScale is 2^((t - karmaEpoch) / KarmaHalfLife).
+func karmaScale(t time.Time) float64 {
+	return math.Exp2(t.Sub(karmaEpoch).Seconds() / KarmaHalfLife.Seconds())
+}
+
+// KarmaAt converts a stored karma value into the karma at t.
+func KarmaAt(stored float64, t time.Time) float64 {
+	return stored / karmaScale(t)
+}
+
+// voteWeight is how much a vote from a user with the given karma counts.
+// Everyone starts at 1 and gains a little per doubling of their karma;
+// users with negative karma count half.
+func voteWeight(karma float64) float64 {
+	if karma < 0 {
+		return 0.5
+	}
+	return math.Min(1+math.Log2(1+karma)/4, maxVoteWeight)
+}
+
+// karmaVote is the stored value of a vote cast at atUnix by a voter whose
+// stored karma is voterStored; sign is 1 for a like and -1 for a dislike.
+// It is registered as the karma_vote SQL function.
+func karmaVote(sign int64, voterStored float64, atUnix int64) float64 {
+	at := time.Unix(atUnix, 0)
+	return float64(sign) * voteWeight(KarmaAt(voterStored, at)) * karmaScale(at)
+}
+
+// karmaSchema makes users.karma follow the karma of the votes on their
+// comments. Reactions other than votes store 0 and are skipped.
+const karmaSchema = `
+	CREATE TRIGGER comment_reactions_karma_insert AFTER INSERT ON comment_reactions
+	WHEN new.karma != 0 BEGIN
+		UPDATE users SET karma = karma + new.karma
+		WHERE id = (SELECT user_id FROM comments WHERE id = new.comment_id);
+	END;
+	CREATE TRIGGER comment_reactions_karma_delete AFTER DELETE ON comment_reactions
+	WHEN old.karma != 0 BEGIN
+		UPDATE users SET karma = karma - old.karma
+		WHERE id = (SELECT user_id FROM comments WHERE id = old.comment_id);
+	END;
+	CREATE TRIGGER comment_reactions_karma_update AFTER UPDATE OF karma ON comment_reactions
+	WHEN new.karma != old.karma BEGIN
+		UPDATE users SET karma = karma - old.karma + new.karma
+		WHERE id = (SELECT user_id FROM comments WHERE id = new.comment_id);
+	END;`
+
+// backfillKarma replays the existing votes in the order they were cast, so
+// each is weighted by the karma its voter had at the time. The triggers add
+// the results up in users.karma.
+func backfillKarma(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT r.comment_id, r.user_id, r.kind = 'like', CAST(strftime('%s', r.created_at) AS INTEGER), COALESCE(c.user_id, 0)
+		FROM comment_reactions r JOIN comments c ON c.id = r.comment_id
+		WHERE r.kind IN ('like', 'dislike')
+		ORDER BY r.created_at, r.comment_id, r.user_id`)
+	if err != nil {
+		return err
+	}
+	type vote struct {
+		commentID, voterID int
+		karma              float64
+	}
+	var votes []vote
+	stored := make(map[int]float64)
+	for rows.Next() {
+		var v vote
+		var like bool
+		var at int64
+		var authorID int
+		if err := rows.Scan(&v.commentID, &v.voterID, &like, &at, &authorID); err != nil {
+			rows.Close()
+			return err
+		}
+		if authorID == v.voterID {
+			continue
+		}
+		var sign int64 = -1
+		if like {
+			sign = 1
+		}
+		v.karma = karmaVote(sign, stored[v.voterID], at)
+		stored[authorID] += v.karma
+		votes = append(votes, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range votes {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE comment_reactions SET karma = ? WHERE comment_id = ? AND user_id = ? AND kind IN ('like', 'dislike')`,
+			v.karma, v.commentID, v.voterID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
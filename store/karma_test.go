@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVoteWeight(t *testing.T) {
+	tests := []struct {
+		karma, want float64
+	}{
+		{-100, 0.5},
+		{-0.1, 0.5},
+		{0, 1},
+		{1, 1.25},
+		{15, 2},
+		{255, 3},
+		{1e9, maxVoteWeight},
+	}
+	for _, tt := range tests {
+		if got := voteWeight(tt.karma); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("voteWeight(%g) = %g, want %g", tt.karma, got, tt.want)
+		}
+	}
+}
+
+func TestKarmaDecay(t *testing.T) {
+	cast := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := karmaVote(1, 0, cast.Unix())
+
+	tests := []struct {
+		after time.Duration
+		want  float64
+	}{
+		{0, 1},
+		{KarmaHalfLife / 2, math.Sqrt2 / 2},
+		{KarmaHalfLife, 0.5},
+		{2 * KarmaHalfLife, 0.25},
+	}
+	for _, tt := range tests {
+		if got := KarmaAt(stored, cast.Add(tt.after)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("karma of a like %v later = %g, want %g", tt.after, got, tt.want)
+		}
+	}
+
+	if got := KarmaAt(karmaVote(-1, 0, cast.Unix()), cast.Add(KarmaHalfLife)); math.Abs(got+0.5) > 1e-9 {
+		t.Errorf("karma of a dislike a half-life later = %g, want -0.5", got)
+	}
+}
+
+func TestKarmaFollowsVotes(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	voter := createUser(t, s, "voter")
+	reputable := createUser(t, s, "reputable")
+	id := createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+
+	// reputable has 15 karma, so their votes count twice.
+	if _, err := db.Exec(`UPDATE users SET karma = ? WHERE id = ?`, 15*karmaScale(time.Now()), reputable.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	karma := func(u User) float64 {
+		t.Helper()
+		got, err := s.Users.ByID(ctx, u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Karma
+	}
+	steps := []struct {
+		name  string
+		voter User
+		vote  string
+		want  float64
+	}{
+		{"like", voter, ReactionLike, 1},
+		{"switch to dislike", voter, ReactionDislike, -1},
+		{"clear", voter, "", 0},
+		{"like again", voter, ReactionLike, 1},
+		{"reputable like", reputable, ReactionLike, 3},
+		{"own comment", author, ReactionDislike, 3},
+	}
+	for _, st := range steps {
+		t.Run(st.name, func(t *testing.T) {
+			if _, err := s.Reactions.Vote(ctx, id, st.voter.ID, st.vote, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := karma(author); math.Abs(got-st.want) > 1e-3 {
+				t.Errorf("author karma = %g, want %g", got, st.want)
+			}
+		})
+	}
+
+	// Purging the comment takes its votes, and their karma, with it.
+	if _, err := s.Comments.Purge(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := karma(author); math.Abs(got) > 1e-9 {
+		t.Errorf("author karma after purge = %g, want 0", got)
+	}
+	if got := karma(reputable); math.Abs(got-15) > 1e-3 {
+		t.Errorf("voter karma changed to %g", got)
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// maxMentions caps how many users one comment can notify.
+const maxMentions = 10
+
+// mentionPattern matches @username where the @ does not follow a word
+// character, so addresses like alice@example.com are not mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// ParseMentions returns the distinct usernames mentioned in body, in order of
+// first appearance. Trailing punctuation such as the "." ending a sentence is
+// not part of the name.
+func ParseMentions(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// MentionStore records which users each comment mentions.
+type MentionStore interface {
+	// Set replaces the mentions of commentID with the users named in body and
+	// returns the IDs of users who were not mentioned before. Names that do
+	// not match a username exactly are ignored.
+	Set(ctx context.Context, commentID int, body string) ([]int, error)
+}
+
+type sqliteMentions struct {
+	db *sql.DB
+}
+
+func (s *sqliteMentions) Set(ctx context.Context, commentID int, body string) ([]int, error) {
+	var added []int
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		added, err = setMentions(ctx, tx, commentID, body)
+		return err
+	})
+	return added, err
+}
+
+func setMentions(ctx context.Context, tx *sql.Tx, commentID int, body string) ([]int, error) {
+	names, err := json.Marshal(ParseMentions(body))
+	if err != nil {
+		return nil, err
+	}
+	args := []any{sql.Named("comment", commentID), sql.Named("names", string(names))}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM comment_mentions
+		WHERE comment_id = :comment
+		  AND user_id NOT IN (SELECT u.id FROM users u JOIN json_each(:names) n ON u.username = n.value)`,
+		args...); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO comment_mentions (comment_id, user_id)
+		SELECT :comment, u.id FROM users u JOIN json_each(:names) n ON u.username = n.value
+		WHERE true
+		ON CONFLICT DO NOTHING
+		RETURNING user_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var added []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		added = append(added, id)
+	}
+	return added, rows.Err()
+}
+
+// backfillMentions records the mentions in comments written before mentions
+// were tracked. Nobody is notified about them.
+func backfillMentions(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, comment FROM comments WHERE deleted_at IS NULL AND comment LIKE '%@%'`)
+	if err != nil {
+		return err
+	}
+	bodies := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return err
+		}
+		bodies[id] = body
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, body := range bodies {
+		if _, err := setMentions(ctx, tx, id, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	var many []string
+	for i := range maxMentions + 2 {
+		many = append(many, fmt.Sprintf("user%d", i))
+	}
+
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"@alice hi", []string{"alice"}},
+		{"thanks @alice.", []string{"alice"}},
+		{"(@alice), @bob!", []string{"alice", "bob"}},
+		{"@alice,@bob", []string{"alice", "bob"}},
+		{"line\n@alice", []string{"alice"}},
+		{"@a.b-c.", []string{"a.b-c"}},
+		{"@_x and @-x", []string{"_x"}},
+		{"@ünïcode", []string{"ünïcode"}},
+		{"@Alice @alice", []string{"Alice", "alice"}},
+		{"mail alice@example.com", nil},
+		{"x@alice", nil},
+		{"@@alice", nil},
+		{"@ alice", nil},
+		{"@alice @bob @alice", []string{"alice", "bob"}},
+		{"@alice. @alice", []string{"alice"}},
+		{"@" + strings.Join(many, " @"), many[:maxMentions]},
+	}
+	for _, tt := range tests {
+		if got := ParseMentions(tt.body); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseMentions(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSetMentions(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	id := createComment(t, s, Comment{UserID: author.ID})
+
+	steps := []struct {
+		body string
+		want []int
+	}{
+		{"@alice and @nobody", []int{alice.ID}},
+		{"@alice and @bob", []int{bob.ID}},
+		{"just @bob", nil},
+		{"@alice again", []int{alice.ID}},
+	}
+	for _, step := range steps {
+		added, err := s.Mentions.Set(ctx, id, step.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(added, step.want) {
+			t.Errorf("Set(%q) = %v, want %v", step.body, added, step.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	users := make(map[string]User)
+	for _, name := range []string{"alice", "Alicia", "albert", "bob", "al%"} {
+		users[name] = createUser(t, s, name)
+	}
+
+	tests := []struct {
+		prefix    string
+		preferred []string
+		limit     int
+		want      []string
+	}{
+		{"al", nil, 10, []string{"al%", "albert", "alice", "Alicia"}},
+		{"ALI", nil, 10, []string{"alice", "Alicia"}},
+		{"alice", nil, 10, []string{"alice"}},
+		{"al", nil, 2, []string{"al%", "albert"}},
+		{"al", []string{"Alicia", "bob"}, 2, []string{"Alicia", "al%"}},
+		{"%", nil, 10, []string{}},
+		{"z", nil, 10, []string{}},
+	}
+	for _, tt := range tests {
+		var preferred []int
+		for _, name := range tt.preferred {
+			preferred = append(preferred, users[name].ID)
+		}
+		suggestions, err := s.Users.Suggest(ctx, tt.prefix, preferred, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, u := range suggestions {
+			got = append(got, u.Username)
+			if u.Connected != slices.Contains(tt.preferred, u.Username) || u.DisplayName != u.Username {
+				t.Errorf("Suggest(%q, %v): %+v", tt.prefix, tt.preferred, u)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Suggest(%q, %v, %d) = %q, want %q", tt.prefix, tt.preferred, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,794 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"myproject/markdown"
+	"myproject/urlcanon"
+)
+
+// Migration is one numbered schema change. Up and Down run inside a
+// transaction together with the schema_migrations bookkeeping. UpFunc, if
+// set, runs after Up for data changes that SQL alone cannot express.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	UpFunc  func(ctx context.Context, tx *sql.Tx) error
+	Down    string
+}
+
+// searchMigration is the version that creates the comment search index.
+const searchMigration = 9
+
+// migrations is the ordered schema history. Never edit an entry that has
+// shipped in a release; append a new one instead. Until then an entry is
+// fixed in place, so the history only holds steps some database has seen.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// IF NOT EXISTS lets databases created by the old createTables adopt
+		// the migration history without changes.
+		Up: `
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE
+		);
+
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			parent_id INTEGER,
+			user_id INTEGER NOT NULL,
+			username TEXT NOT NULL,
+			profile_pic TEXT,
+			comment TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			sentiment_score INTEGER DEFAULT 0,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (parent_id) REFERENCES comments(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS comment_likes (
+			id INTEGER PRIMARY KEY,
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			is_like BOOLEAN NOT NULL,
+			FOREIGN KEY (comment_id) REFERENCES comments(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS connection (
+			user_id INTEGER NOT NULL,
+			comment_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS summaries (
+			url TEXT PRIMARY KEY,
+			summary TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS user_connections (
+			user_id_1 INTEGER NOT NULL,
+			user_id_2 INTEGER NOT NULL,
+			PRIMARY KEY (user_id_1, user_id_2)
+		);`,
+		Down: `
+		DROP TABLE user_connections;
+		DROP TABLE summaries;
+		DROP TABLE connection;
+		DROP TABLE comment_likes;
+		DROP TABLE comments;
+		DROP TABLE users;`,
+	},
+	{
+		Version: 2,
+		Name:    "passwords and sessions",
+		Up: `
+		ALTER TABLE users ADD COLUMN password_hash TEXT;
+
+		CREATE TABLE sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX sessions_user_id ON sessions (user_id);
+
+		CREATE TABLE claim_tokens (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);`,
+		Down: `
+		DROP TABLE claim_tokens;
+		DROP TABLE sessions;
+		ALTER TABLE users DROP COLUMN password_hash;`,
+	},
+	{
+		Version: 3,
+		Name:    "oidc identities",
+		Up: `
+		CREATE TABLE user_identities (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			email TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (provider, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX user_identities_user_id ON user_identities (user_id);
+
+		CREATE TABLE oidc_logins (
+			state TEXT PRIMARY KEY,
+			provider TEXT NOT NULL,
+			nonce TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			user_id INTEGER,
+			return_to TEXT,
+			expires_at TIMESTAMP NOT NULL
+		);`,
+		Down: `
+		DROP TABLE oidc_logins;
+		DROP TABLE user_identities;`,
+	},
+	{
+		Version: 4,
+		Name:    "user public ids",
+		// Internal integer IDs stay as they are, so references from comments,
+		// comment_likes, connection and the graph backends remain valid. New
+		// users get the next rowid instead of a random number, and every user
+		// gets an opaque public_id for use outside the server.
+		Up:     `ALTER TABLE users ADD COLUMN public_id TEXT;`,
+		UpFunc: backfillPublicIDs,
+		Down: `
+		DROP INDEX users_public_id;
+		ALTER TABLE users DROP COLUMN public_id;`,
+	},
+	{
+		Version: 5,
+		Name:    "profiles",
+		// Seed each profile from the user's most recent comment, which is
+		// where the extension used to send the display name and avatar.
+		Up: `
+		CREATE TABLE profiles (
+			user_id INTEGER PRIMARY KEY,
+			display_name TEXT,
+			bio TEXT NOT NULL DEFAULT '',
+			avatar_url TEXT,
+			locale TEXT,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		INSERT INTO profiles (user_id, display_name, avatar_url)
+		SELECT u.id,
+		       (SELECT c.username FROM comments c WHERE c.user_id = u.id ORDER BY c.created_at DESC LIMIT 1),
+		       (SELECT c.profile_pic FROM comments c WHERE c.user_id = u.id AND c.profile_pic != ''
+		        ORDER BY c.created_at DESC LIMIT 1)
+		FROM users u;`,
+		Down: `DROP TABLE profiles;`,
+	},
+	{
+		Version: 6,
+		Name:    "comment revisions",
+		Up: `
+		ALTER TABLE comments ADD COLUMN edited_at TIMESTAMP;
+
+		CREATE TABLE comment_revisions (
+			id INTEGER PRIMARY KEY,
+			comment_id INTEGER NOT NULL,
+			comment TEXT NOT NULL,
+			written_at TIMESTAMP NOT NULL,
+			replaced_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (comment_id) REFERENCES comments(id)
+		);
+		CREATE INDEX comment_revisions_comment_id ON comment_revisions (comment_id);`,
+		Down: `
+		DROP TABLE comment_revisions;
+		ALTER TABLE comments DROP COLUMN edited_at;`,
+	},
+	{
+		Version: 7,
+		Name:    "soft delete and admins",
+		Up: `
+		ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMP;
+		ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT 0;`,
+		Down: `
+		ALTER TABLE users DROP COLUMN is_admin;
+		ALTER TABLE comments DROP COLUMN deleted_at;`,
+	},
+	{
+		Version: 8,
+		Name:    "canonical page urls",
+		// Comments are looked up by canonical URL, so those stored before
+		// canonicalization would vanish from their pages until rewritten.
+		// Reverting leaves the URLs canonical.
+		UpFunc: canonicalizeURLs,
+	},
+	{
+		Version: searchMigration,
+		Name:    "comment search",
+		UpFunc:  createCommentSearch,
+		// The index is missing if the binary lacked FTS5; see EnableSearch.
+		// Control characters stripped from bodies are not restored.
+		Down: `
+		DROP TRIGGER IF EXISTS comments_fts_insert;
+		DROP TRIGGER IF EXISTS comments_fts_delete;
+		DROP TRIGGER IF EXISTS comments_fts_update;
+		DROP TABLE IF EXISTS comments_fts;`,
+	},
+	{
+		Version: 10,
+		Name:    "trending and hot ranking",
+		Up: `
+		ALTER TABLE comments ADD COLUMN hot_score REAL NOT NULL DEFAULT 0;
+		CREATE INDEX comments_url ON comments (url);
+		CREATE INDEX comments_parent_id ON comments (parent_id);
+		CREATE INDEX comment_likes_comment_id ON comment_likes (comment_id);
+
+		CREATE TABLE page_trending (
+			url TEXT NOT NULL,
+			period TEXT NOT NULL,
+			log_score REAL NOT NULL,
+			PRIMARY KEY (url, period)
+		) WITHOUT ROWID;
+		CREATE INDEX page_trending_rank ON page_trending (period, log_score);`,
+		UpFunc: backfillActivity,
+		Down: `
+		DROP TABLE page_trending;
+		DROP INDEX comment_likes_comment_id;
+		DROP INDEX comments_parent_id;
+		DROP INDEX comments_url;
+		ALTER TABLE comments DROP COLUMN hot_score;`,
+	},
+	{
+		Version: 11,
+		Name:    "notifications",
+		// Connecting twice through a comment used to add a second row; the
+		// unique index makes it a no-op so it cannot notify twice either.
+		Up: `
+		DELETE FROM connection
+		WHERE rowid NOT IN (SELECT MIN(rowid) FROM connection GROUP BY user_id, comment_id);
+		CREATE UNIQUE INDEX connection_user_comment ON connection (user_id, comment_id);
+
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			actor_id INTEGER NOT NULL,
+			comment_id INTEGER,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			read_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (actor_id) REFERENCES users(id),
+			FOREIGN KEY (comment_id) REFERENCES comments(id)
+		);
+		CREATE INDEX notifications_user_id ON notifications (user_id, id);
+		CREATE INDEX notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
+		CREATE UNIQUE INDEX notifications_dedup ON notifications (user_id, kind, actor_id, COALESCE(comment_id, 0));`,
+		Down: `
+		DROP TABLE notifications;
+		DROP INDEX connection_user_comment;`,
+	},
+	{
+		Version: 12,
+		Name:    "webhooks",
+		Up: `
+		CREATE TABLE webhooks (
+			id INTEGER PRIMARY KEY,
+			target_url TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			match TEXT NOT NULL DEFAULT '',
+			events TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE webhook_deliveries (
+			id INTEGER PRIMARY KEY,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP,
+			last_status_code INTEGER,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL,
+			delivered_at TIMESTAMP,
+			redelivery_of INTEGER,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id),
+			FOREIGN KEY (redelivery_of) REFERENCES webhook_deliveries(id)
+		);
+		CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+		CREATE INDEX webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';`,
+		Down: `
+		DROP TABLE webhook_deliveries;
+		DROP TABLE webhooks;`,
+	},
+	{
+		Version: 13,
+		Name:    "mentions",
+		Up: `
+		CREATE TABLE comment_mentions (
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (comment_id, user_id),
+			FOREIGN KEY (comment_id) REFERENCES comments(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		) WITHOUT ROWID;
+		CREATE INDEX comment_mentions_user_id ON comment_mentions (user_id);
+		CREATE INDEX users_username_nocase ON users (username COLLATE NOCASE);`,
+		UpFunc: backfillMentions,
+		Down: `
+		DROP INDEX users_username_nocase;
+		DROP TABLE comment_mentions;`,
+	},
+	{
+		Version: 14,
+		Name:    "rendered comments",
+		Up:      `ALTER TABLE comments ADD COLUMN comment_html TEXT NOT NULL DEFAULT '';`,
+		UpFunc:  renderComments,
+		Down:    `ALTER TABLE comments DROP COLUMN comment_html;`,
+	},
+	{
+		Version: 15,
+		Name:    "reactions",
+		// Likes carried no timestamp; they are dated to their comment. Users
+		// who somehow both liked and disliked keep their latest vote.
+		Up: `
+		CREATE TABLE comment_reactions (
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (comment_id, user_id, kind),
+			FOREIGN KEY (comment_id) REFERENCES comments(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		) WITHOUT ROWID;
+		CREATE INDEX comment_reactions_user_id ON comment_reactions (user_id);
+
+		INSERT INTO comment_reactions (comment_id, user_id, kind, created_at)
+		SELECT l.comment_id, l.user_id, CASE WHEN l.is_like THEN 'like' ELSE 'dislike' END,
+		       COALESCE(c.created_at, CURRENT_TIMESTAMP)
+		FROM comment_likes l LEFT JOIN comments c ON c.id = l.comment_id
+		WHERE l.id = (SELECT MAX(l2.id) FROM comment_likes l2
+		              WHERE l2.comment_id = l.comment_id AND l2.user_id = l.user_id);
+
+		DROP TABLE comment_likes;`,
+		Down: `
+		CREATE TABLE comment_likes (
+			id INTEGER PRIMARY KEY,
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			is_like BOOLEAN NOT NULL,
+			FOREIGN KEY (comment_id) REFERENCES comments(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX comment_likes_comment_id ON comment_likes (comment_id);
+
+		INSERT INTO comment_likes (comment_id, user_id, is_like)
+		SELECT comment_id, user_id, kind = 'like' FROM comment_reactions
+		WHERE kind IN ('like', 'dislike')
+		ORDER BY created_at;
+
+		DROP TABLE comment_reactions;`,
+	},
+	{
+		Version: 16,
+		Name:    "one vote per user",
+		// Keep only the newest vote of anyone who has both, the dislike on a
+		// tie, so the index can be created.
+		Up: `
+		DELETE FROM comment_reactions
+		WHERE kind IN ('like', 'dislike') AND EXISTS (
+			SELECT 1 FROM comment_reactions newer
+			WHERE newer.comment_id = comment_reactions.comment_id
+			  AND newer.user_id = comment_reactions.user_id
+			  AND newer.kind IN ('like', 'dislike')
+			  AND newer.kind != comment_reactions.kind
+			  AND newer.created_at >= comment_reactions.created_at
+			  AND (newer.created_at > comment_reactions.created_at OR newer.kind = 'dislike')
+		);
+		CREATE UNIQUE INDEX comment_reactions_vote ON comment_reactions (comment_id, user_id)
+		WHERE kind IN ('like', 'dislike');`,
+		Down: `DROP INDEX comment_reactions_vote;`,
+	},
+	{
+		Version: 17,
+		Name:    "karma",
+		Up: `
+		ALTER TABLE users ADD COLUMN karma REAL NOT NULL DEFAULT 0;
+		ALTER TABLE comment_reactions ADD COLUMN karma REAL NOT NULL DEFAULT 0;` + karmaSchema,
+		UpFunc: backfillKarma,
+		Down: `
+		DROP TRIGGER comment_reactions_karma_insert;
+		DROP TRIGGER comment_reactions_karma_delete;
+		DROP TRIGGER comment_reactions_karma_update;
+		ALTER TABLE comment_reactions DROP COLUMN karma;
+		ALTER TABLE users DROP COLUMN karma;`,
+	},
+	{
+		Version: 18,
+		Name:    "badges",
+		Up: `
+		CREATE TABLE user_badges (
+			user_id INTEGER NOT NULL,
+			badge TEXT NOT NULL,
+			awarded_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, badge),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		) WITHOUT ROWID;
+		CREATE INDEX comments_user_id ON comments (user_id);`,
+		UpFunc: backfillBadges,
+		Down: `
+		DROP INDEX comments_user_id;
+		DROP TABLE user_badges;`,
+	},
+}
+
+// commentSearchSchema indexes comment bodies in an external-content FTS5
+// table that triggers keep in step with comments.
+const commentSearchSchema = `
+	CREATE VIRTUAL TABLE comments_fts USING fts5(
+		comment,
+		content = 'comments',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+	INSERT INTO comments_fts (comments_fts) VALUES ('rebuild');
+
+	CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+		INSERT INTO comments_fts (rowid, comment) VALUES (new.id, new.comment);
+	END;
+	CREATE TRIGGER comments_fts_delete AFTER DELETE ON comments BEGIN
+		INSERT INTO comments_fts (comments_fts, rowid, comment) VALUES ('delete', old.id, old.comment);
+	END;
+	CREATE TRIGGER comments_fts_update AFTER UPDATE OF comment ON comments BEGIN
+		INSERT INTO comments_fts (comments_fts, rowid, comment) VALUES ('delete', old.id, old.comment);
+		INSERT INTO comments_fts (rowid, comment) VALUES (new.id, new.comment);
+	END;`
+
+// createCommentSearch strips control characters from existing comments,
+// which could forge the snippet markers, and builds the search index if this
+// binary's SQLite has FTS5. Without it the migration still succeeds so that
+// the server can start with search disabled; EnableSearch builds the index
+// once a binary with FTS5 runs.
+func createCommentSearch(ctx context.Context, tx *sql.Tx) error {
+	if err := cleanComments(ctx, tx); err != nil {
+		return err
+	}
+	fts5, err := hasFTS5(ctx, tx)
+	if err != nil || !fts5 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, commentSearchSchema)
+	return err
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func hasFTS5(ctx context.Context, q queryRower) (bool, error) {
+	var fts5 bool
+	err := q.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	return fts5, err
+}
+
+func hasSearchIndex(ctx context.Context, q queryRower) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'comments_fts')`).Scan(&exists)
+	return exists, err
+}
+
+// EnableSearch reports whether full-text search is available, first building
+// the index that the comment search migration skipped if this binary has
+// FTS5 and that migration has been applied. Binaries built without the
+// sqlite_fts5 tag report false.
+func EnableSearch(ctx context.Context, db *sql.DB) (bool, error) {
+	if ok, err := hasSearchIndex(ctx, db); err != nil || ok {
+		return ok, err
+	}
+	if fts5, err := hasFTS5(ctx, db); err != nil || !fts5 {
+		return false, err
+	}
+
+	var applied bool
+	err := db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`, searchMigration).Scan(&applied)
+	if err != nil || !applied {
+		return false, err
+	}
+	err = inTx(ctx, db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, commentSearchSchema)
+		return err
+	})
+	return err == nil, err
+}
+
+func backfillPublicIDs(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM users`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		publicID, err := newPublicID()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET public_id = ? WHERE id = ?`, publicID, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE UNIQUE INDEX users_public_id ON users (public_id)`)
+	return err
+}
+
+// renderComments fills in comment_html for comments written before bodies
+// were rendered.
+func renderComments(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, comment FROM comments`)
+	if err != nil {
+		return err
+	}
+	bodies := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return err
+		}
+		bodies[id] = body
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, body := range bodies {
+		if _, err := tx.ExecContext(ctx, `UPDATE comments SET comment_html = ? WHERE id = ?`, markdown.Render(body), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanComments strips control characters from the bodies written before
+// cleanBody existed. Revisions are not searched and keep their original text.
+func cleanComments(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, comment FROM comments`)
+	if err != nil {
+		return err
+	}
+	bodies := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return err
+		}
+		if clean := cleanBody(body); clean != body {
+			bodies[id] = clean
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, body := range bodies {
+		if _, err := tx.ExecContext(ctx, `UPDATE comments SET comment = ? WHERE id = ?`, body, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanonicalizeURL rewrites page URLs for the canonical page urls migration.
+// The server sets it from its configured rules before migrating; when nil,
+// urlcanon's default rules apply.
+var CanonicalizeURL func(raw string) (string, error)
+
+// canonicalizeURLs moves the comments on every stored URL to its canonical
+// form and drops the cached summaries of both pages. URLs that cannot be
+// canonicalized are left alone.
+func canonicalizeURLs(ctx context.Context, tx *sql.Tx) error {
+	canonicalize := CanonicalizeURL
+	if canonicalize == nil {
+		canonicalize = urlcanon.New(urlcanon.Rules{StripParams: urlcanon.DefaultStripParams, StripWWW: true}).Canonicalize
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT url FROM comments`)
+	if err != nil {
+		return err
+	}
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			rows.Close()
+			return err
+		}
+		urls = append(urls, url)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		canonical, err := canonicalize(url)
+		if err != nil || canonical == url {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE comments SET url = ? WHERE url = ?`, canonical, url); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM summaries WHERE url IN (?, ?)`, url, canonical); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationState reports whether a migration has been applied.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func ensureMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	return err
+}
+
+// MigrationStatus lists every known migration with its applied time, if any.
+func MigrationStatus(ctx context.Context, db *sql.DB) ([]MigrationState, error) {
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &at
+		}
+	}
+	return states, nil
+}
+
+// Pending returns the migrations that MigrateUp would apply.
+func Pending(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	states, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range states {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies every pending migration in version order and returns
+// the ones it ran.
+func MigrateUp(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	pending, err := Pending(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+				return err
+			}
+			if m.UpFunc != nil {
+				if err := m.UpFunc(ctx, tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the most recent steps applied migrations and returns
+// the ones it reverted, newest first.
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	states, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(states) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if states[i].AppliedAt == nil {
+			continue
+		}
+		m := states[i].Migration
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// schema lists the tables, indexes and triggers in db with their SQL.
+func schema(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	objects := make(map[string]string)
+	for rows.Next() {
+		var name, sql string
+		if err := rows.Scan(&name, &sql); err != nil {
+			t.Fatal(err)
+		}
+		objects[name] = sql
+	}
+	return objects
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d has version %d; versions must be consecutive", i+1, m.Version)
+		}
+	}
+
+	applied, err := MigrateUp(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("MigrateUp applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	if pending, err := Pending(ctx, db); err != nil || len(pending) != 0 {
+		t.Fatalf("Pending after MigrateUp = %v, %v", pending, err)
+	}
+	if again, err := MigrateUp(ctx, db); err != nil || len(again) != 0 {
+		t.Fatalf("second MigrateUp = %v, %v; want nothing to do", again, err)
+	}
+	full := schema(t, db)
+
+	// Revert one step at a time, newest first.
+	for i := len(migrations) - 1; i >= 0; i-- {
+		reverted, err := MigrateDown(ctx, db, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != migrations[i].Version {
+			t.Fatalf("MigrateDown reverted %v, want migration %d", reverted, migrations[i].Version)
+		}
+	}
+	if reverted, err := MigrateDown(ctx, db, 1); err != nil || len(reverted) != 0 {
+		t.Fatalf("MigrateDown with nothing applied = %v, %v", reverted, err)
+	}
+
+	if left := schema(t, db); len(left) != 0 {
+		t.Errorf("schema after reverting everything: %v", left)
+	}
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if !sameSchema(full, schema(t, db)) {
+		t.Error("migrating up again produced a different schema")
+	}
+}
+
+func sameSchema(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sql := range a {
+		if b[name] != sql {
+			return false
+		}
+	}
+	return true
+}
+
+// TestMigrateLegacyDatabase upgrades a database made by the old
+// createTables, whose comments predate URL canonicalization.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, username TEXT NOT NULL UNIQUE);
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL, parent_id INTEGER,
+			user_id INTEGER NOT NULL, username TEXT NOT NULL, profile_pic TEXT, comment TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, sentiment_score INTEGER DEFAULT 0
+		);
+		CREATE TABLE comment_likes (id INTEGER PRIMARY KEY, comment_id INTEGER NOT NULL, user_id INTEGER NOT NULL, is_like BOOLEAN NOT NULL);
+		CREATE TABLE connection (user_id INTEGER NOT NULL, comment_id INTEGER NOT NULL);
+		CREATE TABLE summaries (url TEXT PRIMARY KEY, summary TEXT NOT NULL);
+		CREATE TABLE user_connections (user_id_1 INTEGER NOT NULL, user_id_2 INTEGER NOT NULL, PRIMARY KEY (user_id_1, user_id_2));
+
+		INSERT INTO users (id, username) VALUES (12345678, 'Nevin S Eluvathingal');
+		INSERT INTO comments (url, user_id, username, comment) VALUES
+			('www.ey.com/en_in', 12345678, 'Nevin S Eluvathingal', 'legacy'),
+			('www.ey.com/en_in', 133663637, 'no account', 'legacy'),
+			('https://example.com/?utm_source=x', 12345678, 'Nevin S Eluvathingal', 'tra' || char(2) || 'cked'),
+			('mailto:a@b.c', 12345678, 'Nevin S Eluvathingal', 'not a page');
+		INSERT INTO comment_likes (comment_id, user_id, is_like) VALUES (1, 12345678, 1);
+		INSERT INTO connection (user_id, comment_id) VALUES (12345678, 2), (12345678, 2);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSQLite(db)
+	user, err := s.Users.ByID(ctx, 12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PublicID == "" {
+		t.Error("legacy user has no public ID")
+	}
+
+	urls, err := s.Comments.URLs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(urls)
+	want := []string{"https://example.com/", "https://ey.com/en_in", "mailto:a@b.c"}
+	if !slices.Equal(urls, want) {
+		t.Errorf("URLs after migrating = %v, want %v", urls, want)
+	}
+
+	if c, err := s.Comments.Get(ctx, 3, 0); err != nil || c.Comment != "tracked" || c.CommentHTML != "<p>tracked</p>\n" {
+		t.Errorf("legacy comment with a control character = %+v, %v", c, err)
+	}
+
+	var orphanBadges int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user_badges WHERE user_id = 133663637`).Scan(&orphanBadges); err != nil {
+		t.Fatal(err)
+	}
+	if orphanBadges != 0 {
+		t.Errorf("author without an account got %d badges", orphanBadges)
+	}
+	if badges, err := s.Badges.List(ctx, 12345678); err != nil || len(badges) != 1 || badges[0].Name != "first_comment" {
+		t.Errorf("legacy user's badges = %+v, %v; want first_comment", badges, err)
+	}
+
+	var connections int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM connection`).Scan(&connections); err != nil {
+		t.Fatal(err)
+	}
+	if connections != 1 {
+		t.Errorf("%d connection rows after migrating, want the duplicate removed", connections)
+	}
+
+	comments, _, err := s.Comments.ListTopLevel(ctx, PageFilter{URL: "https://ey.com/en_in"}, 0, Page{Sort: SortOldest, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || comments[0].LikeCount != 1 {
+		t.Errorf("legacy comments on their canonical URL = %+v", comments)
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Notification kinds.
+const (
+	NotifyReply      = "reply"
+	NotifyLike       = "like"
+	NotifyConnection = "connection"
+	NotifyMention    = "mention"
+)
+
+// Notification tells a user that someone else acted on their comment or
+// connected with them.
+type Notification struct {
+	ID            int    `json:"id"`
+	Kind          string `json:"kind"`
+	ActorID       int    `json:"actor_id"`
+	ActorPublicID string `json:"actor_public_id,omitempty"`
+	ActorName     string `json:"actor_name"`
+	// CommentID is the reply or mentioning comment, or the liked or
+	// connected-through comment.
+	CommentID *int   `json:"comment_id,omitempty"`
+	URL       string `json:"url,omitempty"`
+	CreatedAt string `json:"created_at"`
+	Read      bool   `json:"read"`
+}
+
+// NotificationStore is each user's notification inbox.
+type NotificationStore interface {
+	// Add notifies userID that actorID did kind. commentID may be 0. It does
+	// nothing if the same notification already exists, so undoing and
+	// redoing an action does not notify twice.
+	Add(ctx context.Context, userID int, kind string, actorID, commentID int) error
+	// List returns a page of userID's notifications, newest first, and the
+	// cursor of the next page. page.Sort is ignored.
+	List(ctx context.Context, userID int, unreadOnly bool, page Page) ([]Notification, string, error)
+	UnreadCount(ctx context.Context, userID int) (int, error)
+	// MarkRead returns ErrNotFound unless the notification belongs to userID.
+	MarkRead(ctx context.Context, userID, id int) error
+	// MarkAllRead returns how many notifications were unread.
+	MarkAllRead(ctx context.Context, userID int) (int, error)
+}
+
+type sqliteNotifications struct {
+	db *sql.DB
+}
+
+func (s *sqliteNotifications) Add(ctx context.Context, userID int, kind string, actorID, commentID int) error {
+	var comment *int
+	if commentID != 0 {
+		comment = &commentID
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO notifications (user_id, kind, actor_id, comment_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		userID, kind, actorID, comment)
+	return err
+}
+
+func (s *sqliteNotifications) List(ctx context.Context, userID int, unreadOnly bool, page Page) ([]Notification, string, error) {
+	args := []any{sql.Named("user", userID), sql.Named("limit", page.Limit+1)}
+	where := "n.user_id = :user"
+	if unreadOnly {
+		where += " AND n.read_at IS NULL"
+	}
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, SortNewest)
+		if err != nil {
+			return nil, "", err
+		}
+		where += " AND n.id < :before"
+		args = append(args, sql.Named("before", c.ID))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT n.id, n.kind, n.actor_id, u.public_id, COALESCE(p.display_name, u.username, ''),
+		       n.comment_id, c.url, n.created_at, n.read_at IS NOT NULL
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		LEFT JOIN profiles p ON p.user_id = n.actor_id
+		LEFT JOIN comments c ON c.id = n.comment_id
+		WHERE `+where+`
+		ORDER BY n.id DESC
+		LIMIT :limit`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		if len(notifications) == page.Limit {
+			last := notifications[len(notifications)-1].ID
+			return notifications, cursor{Sort: SortNewest, Key: float64(last), ID: last}.encode(), nil
+		}
+		var n Notification
+		var publicID, url sql.NullString
+		if err := rows.Scan(&n.ID, &n.Kind, &n.ActorID, &publicID, &n.ActorName,
+			&n.CommentID, &url, &n.CreatedAt, &n.Read); err != nil {
+			return nil, "", err
+		}
+		n.ActorPublicID = publicID.String
+		n.URL = url.String
+		notifications = append(notifications, n)
+	}
+	return notifications, "", rows.Err()
+}
+
+func (s *sqliteNotifications) UnreadCount(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteNotifications) MarkRead(ctx context.Context, userID, id int) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteNotifications) MarkAllRead(ctx context.Context, userID int) (int, error) {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNotificationsDeduplicate(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	comment := createComment(t, s, Comment{UserID: alice.ID, Username: "alice"})
+	other := createComment(t, s, Comment{UserID: alice.ID, Username: "alice"})
+
+	adds := []struct {
+		name      string
+		userID    int
+		kind      string
+		actorID   int
+		commentID int
+		wantNew   bool
+	}{
+		{"first like", alice.ID, NotifyLike, bob.ID, comment, true},
+		{"like again", alice.ID, NotifyLike, bob.ID, comment, false},
+		{"another kind", alice.ID, NotifyConnection, bob.ID, comment, true},
+		{"another actor", alice.ID, NotifyLike, carol.ID, comment, true},
+		{"another comment", alice.ID, NotifyLike, bob.ID, other, true},
+		{"another recipient", carol.ID, NotifyLike, bob.ID, comment, true},
+		{"no comment", alice.ID, NotifyConnection, carol.ID, 0, true},
+		{"no comment again", alice.ID, NotifyConnection, carol.ID, 0, false},
+	}
+	counts := make(map[int]int)
+	for _, a := range adds {
+		t.Run(a.name, func(t *testing.T) {
+			if err := s.Notifications.Add(ctx, a.userID, a.kind, a.actorID, a.commentID); err != nil {
+				t.Fatal(err)
+			}
+			if a.wantNew {
+				counts[a.userID]++
+			}
+			if n, err := s.Notifications.UnreadCount(ctx, a.userID); err != nil || n != counts[a.userID] {
+				t.Errorf("UnreadCount = %d, %v; want %d", n, err, counts[a.userID])
+			}
+		})
+	}
+
+	// A read notification still counts as a duplicate.
+	if _, err := s.Notifications.MarkAllRead(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Notifications.Add(ctx, alice.ID, NotifyLike, bob.ID, comment); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Notifications.UnreadCount(ctx, alice.ID); err != nil || n != 0 {
+		t.Errorf("UnreadCount after repeating a read notification = %d, %v; want 0", n, err)
+	}
+}
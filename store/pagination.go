@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not produced by the same
+// listing and sort order.
+var ErrInvalidCursor = errors.New("store: invalid cursor")
+
+// Sort orders a comment listing.
+type Sort string
+
+const (
+	SortNewest Sort = "newest"
+	SortOldest Sort = "oldest"
+	// SortTop ranks by likes minus dislikes.
+	SortTop Sort = "top"
+	// SortControversial ranks comments with many, evenly split votes first.
+	SortControversial Sort = "controversial"
+	// SortBest ranks by the Wilson score lower bound of the like ratio, so a
+	// few votes count for less than many.
+	SortBest Sort = "best"
+	// SortHot ranks by net votes with a bonus for recency; see hotRank.
+	SortHot Sort = "hot"
+	// SortKarma puts comments by the most reputable authors first.
+	SortKarma Sort = "karma"
+)
+
+// sortKeys is the SQL expression each sort orders by, evaluated over the
+// columns of commentProjection (available as listing), and whether it is
+// ascending. Ties are broken by id in the same direction.
+var sortKeys = map[Sort]struct {
+	expr string
+	asc  bool
+}{
+	SortNewest: {`julianday(created_at)`, false},
+	SortOldest: {`julianday(created_at)`, true},
+	SortTop:    {`like_count - dislike_count`, false},
+	SortControversial: {`CASE WHEN like_count = 0 OR dislike_count = 0 THEN 0
+		ELSE (like_count + dislike_count) * MIN(like_count, dislike_count) * 1.0 / MAX(like_count, dislike_count) END`, false},
+	SortBest:  {`wilson_lower_bound(like_count, dislike_count)`, false},
+	SortHot:   {`(SELECT h.hot_score FROM comments h WHERE h.id = listing.id)`, false},
+	SortKarma: {`author_karma`, false},
+}
+
+// ParseSort validates a sort name; the empty string means SortOldest.
+func ParseSort(s string) (Sort, error) {
+	if s == "" {
+		return SortOldest, nil
+	}
+	if _, ok := sortKeys[Sort(s)]; !ok {
+		return "", fmt.Errorf("unknown sort %q", s)
+	}
+	return Sort(s), nil
+}
+
+// Sorts lists the sorts ParseSort accepts in alphabetical order.
+func Sorts() []Sort {
+	sorts := make([]Sort, 0, len(sortKeys))
+	for s := range sortKeys {
+		sorts = append(sorts, s)
+	}
+	slices.Sort(sorts)
+	return sorts
+}
+
+// Page selects a slice of a listing. An empty Cursor starts at the top; pass
+// the next cursor returned with one page to get the following page.
+type Page struct {
+	Sort   Sort
+	Limit  int
+	Cursor string
+}
+
+// cursor is the position after the last comment of a page. It is encoded as
+// base64 JSON so clients treat it as opaque.
+type cursor struct {
+	Sort Sort    `json:"s"`
+	Key  float64 `json:"k"`
+	ID   int     `json:"i"`
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, sort Sort) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Sort != sort {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// queryPage runs commentProjection filtered by where and returns one page of
+// it in page.Sort order, plus the cursor of the next page or "" on the last
+// page.
+func (s *sqliteComments) queryPage(ctx context.Context, where string, page Page, args ...any) ([]Comment, string, error) {
+	key, ok := sortKeys[page.Sort]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown sort %q", page.Sort)
+	}
+	cmp, dir := "<", "DESC"
+	if key.asc {
+		cmp, dir = ">", "ASC"
+	}
+
+	after := "1"
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, page.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+		after = fmt.Sprintf(`(sort_key, id) %s (:after_key, :after_id)`, cmp)
+		args = append(args, sql.Named("after_key", c.Key), sql.Named("after_id", c.ID))
+	}
+
+	// One extra row tells whether there is a next page.
+	args = append(args, sql.Named("limit", page.Limit+1))
+	rows, err := s.db.QueryContext(ctx, `
+		WITH listing AS (`+commentProjection+` `+where+`),
+		keyed AS (SELECT *, `+key.expr+` AS sort_key FROM listing)
+		SELECT * FROM keyed
+		WHERE `+after+`
+		ORDER BY sort_key `+dir+`, id `+dir+`
+		LIMIT :limit`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	var last cursor
+	for rows.Next() {
+		if len(comments) == page.Limit {
+			return comments, last.encode(), nil
+		}
+		var c Comment
+		var sortKey float64
+		if err := scanComment(rows, &c, &sortKey); err != nil {
+			return nil, "", err
+		}
+		last = cursor{Sort: page.Sort, Key: sortKey, ID: c.ID}
+		comments = append(comments, c)
+	}
+	return comments, "", rows.Err()
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Sort
+		wantErr bool
+	}{
+		{"", SortOldest, false},
+		{"newest", SortNewest, false},
+		{"karma", SortKarma, false},
+		{"Newest", "", true},
+		{"relevance", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSort(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseSort(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if sorts := Sorts(); len(sorts) != len(sortKeys) || !slices.IsSorted(sorts) {
+		t.Errorf("Sorts() = %v, want every sort in order", sorts)
+	}
+}
+
+// TestCursorPagination pages through a listing in every sort order and
+// checks that the pages add up to the whole listing, including when sort
+// keys tie.
+func TestCursorPagination(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	var voters []User
+	for _, name := range []string{"v1", "v2", "v3", "v4"} {
+		voters = append(voters, createUser(t, s, name))
+	}
+	author := createUser(t, s, "author")
+
+	// Likes and dislikes per comment; several comments share a score.
+	votes := [][2]int{{0, 0}, {2, 0}, {1, 1}, {2, 0}, {0, 2}, {3, 1}, {1, 0}, {0, 0}, {2, 2}, {1, 0}, {4, 0}}
+	for _, v := range votes {
+		id := createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+		for i := 0; i < v[0]+v[1]; i++ {
+			kind := ReactionLike
+			if i >= v[0] {
+				kind = ReactionDislike
+			}
+			if _, err := s.Reactions.Vote(ctx, id, voters[i].ID, kind, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	filter := PageFilter{URL: "https://example.com/"}
+
+	for _, sort := range Sorts() {
+		for _, limit := range []int{1, 3, 4, len(votes)} {
+			all, next, err := s.Comments.ListTopLevel(ctx, filter, 0, Page{Sort: sort, Limit: len(votes) + 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != len(votes) || next != "" {
+				t.Fatalf("%s: full listing has %d comments and cursor %q", sort, len(all), next)
+			}
+
+			var paged []int
+			page := Page{Sort: sort, Limit: limit}
+			for pages := 0; ; pages++ {
+				if pages > len(votes) {
+					t.Fatalf("%s limit %d: pagination does not end", sort, limit)
+				}
+				comments, next, err := s.Comments.ListTopLevel(ctx, filter, 0, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(comments) > limit {
+					t.Fatalf("%s limit %d: page has %d comments", sort, limit, len(comments))
+				}
+				for _, c := range comments {
+					paged = append(paged, c.ID)
+				}
+				if next == "" {
+					break
+				}
+				page.Cursor = next
+			}
+
+			var want []int
+			for _, c := range all {
+				want = append(want, c.ID)
+			}
+			if !slices.Equal(paged, want) {
+				t.Errorf("%s limit %d: pages = %v, want %v", sort, limit, paged, want)
+			}
+		}
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	for range 3 {
+		createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+	}
+	filter := PageFilter{URL: "https://example.com/"}
+
+	_, newest, err := s.Comments.ListTopLevel(ctx, filter, 0, Page{Sort: SortNewest, Limit: 1})
+	if err != nil || newest == "" {
+		t.Fatalf("first page: cursor %q, err %v", newest, err)
+	}
+
+	tests := []struct {
+		name   string
+		sort   Sort
+		cursor string
+	}{
+		{"other sort", SortTop, newest},
+		{"not base64", SortNewest, "!!!"},
+		{"not JSON", SortNewest, "bm90IGpzb24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.Comments.ListTopLevel(ctx, filter, 0, Page{Sort: tt.sort, Limit: 1, Cursor: tt.cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Profile is the public, user-editable part of an account.
+type Profile struct {
+	UserID      int     `json:"user_id"`
+	PublicID    string  `json:"public_id"`
+	Username    string  `json:"username"`
+	DisplayName string  `json:"display_name"`
+	Bio         string  `json:"bio"`
+	AvatarURL   string  `json:"avatar_url"`
+	Locale      string  `json:"locale"`
+	UpdatedAt   *string `json:"updated_at"`
+	// Karma is rounded; see KarmaHalfLife.
+	Karma  int         `json:"karma"`
+	Badges []UserBadge `json:"badges"`
+}
+
+// ProfileUpdate holds the fields of a partial update; nil leaves a field as
+// it is and an empty string clears it.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+}
+
+type ProfileStore interface {
+	// Get returns ErrNotFound if the user does not exist. Users without a
+	// profile row get one with the display name defaulting to the username.
+	Get(ctx context.Context, userID int) (Profile, error)
+	Update(ctx context.Context, userID int, u ProfileUpdate) error
+}
+
+type sqliteProfiles struct {
+	db *sql.DB
+}
+
+func (s *sqliteProfiles) Get(ctx context.Context, userID int) (Profile, error) {
+	var p Profile
+	var displayName, avatarURL, locale, bio sql.NullString
+	var karma float64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.id, u.public_id, u.username, p.display_name, p.bio, p.avatar_url, p.locale, p.updated_at, u.karma
+		FROM users u LEFT JOIN profiles p ON p.user_id = u.id
+		WHERE u.id = ?`, userID).
+		Scan(&p.UserID, &p.PublicID, &p.Username, &displayName, &bio, &avatarURL, &locale, &p.UpdatedAt, &karma)
+	if err != nil {
+		return p, notFound(err)
+	}
+
+	p.DisplayName = displayName.String
+	if !displayName.Valid {
+		p.DisplayName = p.Username
+	}
+	p.Bio = bio.String
+	p.AvatarURL = avatarURL.String
+	p.Locale = locale.String
+	p.Karma = int(math.Round(KarmaAt(karma, time.Now())))
+	p.Badges, err = listBadges(ctx, s.db, userID)
+	return p, err
+}
+
+func (s *sqliteProfiles) Update(ctx context.Context, userID int, u ProfileUpdate) error {
+	// NULLIF turns "" into NULL so clearing a field falls back to the default.
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO profiles (user_id, display_name, bio, avatar_url, locale)
+		VALUES (:user, NULLIF(:name, ''), COALESCE(:bio, ''), NULLIF(:avatar, ''), NULLIF(:locale, ''))
+		ON CONFLICT (user_id) DO UPDATE SET
+			display_name = CASE WHEN :name IS NULL THEN display_name ELSE NULLIF(:name, '') END,
+			bio = COALESCE(:bio, bio),
+			avatar_url = CASE WHEN :avatar IS NULL THEN avatar_url ELSE NULLIF(:avatar, '') END,
+			locale = CASE WHEN :locale IS NULL THEN locale ELSE NULLIF(:locale, '') END,
+			updated_at = CURRENT_TIMESTAMP`,
+		sql.Named("user", userID), sql.Named("name", u.DisplayName), sql.Named("bio", u.Bio),
+		sql.Named("avatar", u.AvatarURL), sql.Named("locale", u.Locale))
+	return err
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	id := createComment(t, s, Comment{UserID: alice.ID, Username: "alice", ProfilePic: "https://old.example/a.png"})
+
+	if _, err := s.Profiles.Get(ctx, alice.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of unknown user: err = %v, want ErrNotFound", err)
+	}
+
+	str := func(s string) *string { return &s }
+	steps := []struct {
+		name   string
+		update ProfileUpdate
+		want   Profile
+		// wantPic is the avatar comments show.
+		wantPic string
+	}{
+		{"no profile yet", ProfileUpdate{}, Profile{DisplayName: "alice"}, "https://old.example/a.png"},
+		{"set fields", ProfileUpdate{DisplayName: str("Alice A."), Bio: str("hi"), AvatarURL: str("https://new.example/a.png"), Locale: str("en-GB")},
+			Profile{DisplayName: "Alice A.", Bio: "hi", AvatarURL: "https://new.example/a.png", Locale: "en-GB"}, "https://new.example/a.png"},
+		{"partial update", ProfileUpdate{Bio: str("hello")},
+			Profile{DisplayName: "Alice A.", Bio: "hello", AvatarURL: "https://new.example/a.png", Locale: "en-GB"}, "https://new.example/a.png"},
+		{"clear fields", ProfileUpdate{DisplayName: str(""), AvatarURL: str(""), Locale: str("")},
+			Profile{DisplayName: "alice", Bio: "hello"}, "https://old.example/a.png"},
+	}
+	for i, step := range steps {
+		if step.update != (ProfileUpdate{}) {
+			if err := s.Profiles.Update(ctx, alice.ID, step.update); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err := s.Profiles.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UserID != alice.ID || p.PublicID != alice.PublicID || p.Username != "alice" ||
+			p.DisplayName != step.want.DisplayName || p.Bio != step.want.Bio ||
+			p.AvatarURL != step.want.AvatarURL || p.Locale != step.want.Locale {
+			t.Errorf("%s: Get = %+v, want %+v", step.name, p, step.want)
+		}
+		if (p.UpdatedAt == nil) != (i == 0) {
+			t.Errorf("%s: updated_at = %v, want it set once the profile is saved", step.name, p.UpdatedAt)
+		}
+
+		// Comments show the current profile, not the name they were
+		// posted under.
+		c, err := s.Comments.Get(ctx, id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Username != step.want.DisplayName || c.ProfilePic != step.wantPic {
+			t.Errorf("%s: comment shows %q, %q; want %q, %q", step.name, c.Username, c.ProfilePic, step.want.DisplayName, step.wantPic)
+		}
+	}
+}
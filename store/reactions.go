@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// The reaction kinds that stand for the original likes and dislikes. They
+// feed like_count, dislike_count, like_status and the vote-based sorts.
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// ReactionStore holds the reactions users leave on comments. A user may
+// react to a comment with several kinds; which kinds exclude each other is
+// up to the caller.
+type ReactionStore interface {
+	// Mine returns the kinds userID reacted to the comment with.
+	Mine(ctx context.Context, commentID, userID int) ([]string, error)
+	// Add records a reaction of kind and removes the user's reactions of
+	// the replaced kinds. It returns false if the reaction already existed,
+	// and ErrNotFound if the comment does not.
+	Add(ctx context.Context, commentID, userID int, kind string, replaces []string) (bool, error)
+	// Remove returns false if there was no such reaction.
+	Remove(ctx context.Context, commentID, userID int, kind string) (bool, error)
+	// Vote sets the user's vote on a comment to ReactionLike,
+	// ReactionDislike or, for "", no vote, and removes the user's reactions
+	// of the replaced kinds. It is a single upsert, so repeating it changes
+	// nothing; it returns whether the vote changed.
+	Vote(ctx context.Context, commentID, userID int, vote string, replaces []string) (bool, error)
+}
+
+type sqliteReactions struct {
+	db *sql.DB
+}
+
+func (s *sqliteReactions) Mine(ctx context.Context, commentID, userID int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT kind FROM comment_reactions WHERE comment_id = ? AND user_id = ? ORDER BY kind`,
+		commentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kinds := []string{}
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, rows.Err()
+}
+
+// reactionKarma is the karma a new reaction of :kind by :user on comment c
+// carries at :at_unix; see karmaVote. Only votes count, and not on one's own
+// comments.
+const reactionKarma = `CASE WHEN :kind NOT IN ('like', 'dislike') OR c.user_id = :user THEN 0
+	ELSE karma_vote(CASE :kind WHEN 'like' THEN 1 ELSE -1 END,
+	                COALESCE((SELECT karma FROM users WHERE id = :user), 0), :at_unix) END`
+
+// Every change refreshes the comment's hot rank, and each new like counts
+// towards its page trending.
+
+func (s *sqliteReactions) Add(ctx context.Context, commentID, userID int, kind string, replaces []string) (bool, error) {
+	if replaces == nil {
+		replaces = []string{}
+	}
+	replaced, err := json.Marshal(replaces)
+	if err != nil {
+		return false, err
+	}
+
+	var added bool
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var url string
+		if err := tx.QueryRowContext(ctx, `SELECT url FROM comments WHERE id = ?`, commentID).Scan(&url); err != nil {
+			return notFound(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM comment_reactions
+			WHERE comment_id = ? AND user_id = ? AND kind IN (SELECT value FROM json_each(?)) AND kind != ?`,
+			commentID, userID, string(replaced), kind); err != nil {
+			return err
+		}
+		at := now()
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO comment_reactions (comment_id, user_id, kind, created_at, karma)
+			SELECT :comment, :user, :kind, :at, `+reactionKarma+` FROM comments c WHERE c.id = :comment
+			ON CONFLICT DO NOTHING`,
+			sql.Named("comment", commentID), sql.Named("user", userID), sql.Named("kind", kind),
+			sql.Named("at", at), sql.Named("at_unix", at.Unix()))
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		added = n > 0
+
+		if err := refreshHot(ctx, tx, commentID); err != nil {
+			return err
+		}
+		if added && kind == ReactionLike {
+			return recordActivity(ctx, tx, url, likeWeight, now())
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (s *sqliteReactions) Vote(ctx context.Context, commentID, userID int, vote string, replaces []string) (bool, error) {
+	if replaces == nil {
+		replaces = []string{}
+	}
+	replaced, err := json.Marshal(replaces)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var result sql.Result
+		var err error
+		if vote == "" {
+			result, err = tx.ExecContext(ctx, `
+				DELETE FROM comment_reactions
+				WHERE comment_id = ? AND user_id = ? AND kind IN ('like', 'dislike')`,
+				commentID, userID)
+		} else {
+			at := now()
+			result, err = tx.ExecContext(ctx, `
+				INSERT INTO comment_reactions (comment_id, user_id, kind, created_at, karma)
+				SELECT :comment, :user, :kind, :at, `+reactionKarma+` FROM comments c WHERE c.id = :comment
+				ON CONFLICT (comment_id, user_id) WHERE kind IN ('like', 'dislike')
+				DO UPDATE SET kind = excluded.kind, created_at = excluded.created_at, karma = excluded.karma
+				WHERE kind != excluded.kind`,
+				sql.Named("comment", commentID), sql.Named("user", userID), sql.Named("kind", vote),
+				sql.Named("at", at), sql.Named("at_unix", at.Unix()))
+		}
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		changed = n > 0
+
+		// Checked after writing so the transaction takes the write lock
+		// first; a missing comment rolls the vote back.
+		var url string
+		if err := tx.QueryRowContext(ctx, `SELECT url FROM comments WHERE id = ?`, commentID).Scan(&url); err != nil {
+			return notFound(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM comment_reactions
+			WHERE comment_id = ? AND user_id = ? AND kind IN (SELECT value FROM json_each(?))
+			  AND kind NOT IN ('like', 'dislike')`,
+			commentID, userID, string(replaced)); err != nil {
+			return err
+		}
+
+		if !changed {
+			return nil
+		}
+		if err := refreshHot(ctx, tx, commentID); err != nil {
+			return err
+		}
+		if vote == ReactionLike {
+			return recordActivity(ctx, tx, url, likeWeight, now())
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func (s *sqliteReactions) Remove(ctx context.Context, commentID, userID int, kind string) (bool, error) {
+	var removed bool
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM comment_reactions WHERE comment_id = ? AND user_id = ? AND kind = ?`,
+			commentID, userID, kind)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		removed = n > 0
+		return refreshHot(ctx, tx, commentID)
+	})
+	return removed, err
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// votes counts the like and dislike rows of userID on commentID.
+func votes(t *testing.T, db *sql.DB, commentID, userID int) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM comment_reactions
+		WHERE comment_id = ? AND user_id = ? AND kind IN ('like', 'dislike')`, commentID, userID).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestVote(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	voter := createUser(t, s, "voter")
+	id := createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+
+	steps := []struct {
+		name                string
+		vote                string
+		wantChanged         bool
+		wantLikes, wantDown int
+	}{
+		{"like", ReactionLike, true, 1, 0},
+		{"like again", ReactionLike, false, 1, 0},
+		{"switch to dislike", ReactionDislike, true, 0, 1},
+		{"dislike again", ReactionDislike, false, 0, 1},
+		{"switch to like", ReactionLike, true, 1, 0},
+		{"clear", "", true, 0, 0},
+		{"clear again", "", false, 0, 0},
+		{"dislike after clearing", ReactionDislike, true, 0, 1},
+	}
+	for _, st := range steps {
+		t.Run(st.name, func(t *testing.T) {
+			changed, err := s.Reactions.Vote(ctx, id, voter.ID, st.vote, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != st.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, st.wantChanged)
+			}
+			c, err := s.Comments.Get(ctx, id, voter.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.LikeCount != st.wantLikes || c.DislikeCount != st.wantDown {
+				t.Errorf("counts = %d likes, %d dislikes; want %d, %d", c.LikeCount, c.DislikeCount, st.wantLikes, st.wantDown)
+			}
+			if want := st.wantLikes + st.wantDown; votes(t, db, id, voter.ID) != want {
+				t.Errorf("%d vote rows, want %d", votes(t, db, id, voter.ID), want)
+			}
+		})
+	}
+
+	// The partial unique index allows one vote per user, whatever its kind,
+	// next to any number of other reactions.
+	_, err := db.Exec(`INSERT INTO comment_reactions (comment_id, user_id, kind, created_at) VALUES (?, ?, 'like', ?)`,
+		id, voter.ID, now())
+	if !errors.Is(conflict(err), ErrConflict) {
+		t.Errorf("inserting a second vote: err = %v, want a uniqueness violation", err)
+	}
+	if _, err := s.Reactions.Add(ctx, id, voter.ID, "laugh", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reactions.Vote(ctx, id, voter.ID, ReactionLike, []string{"laugh"}); err != nil {
+		t.Fatal(err)
+	}
+	if mine, err := s.Reactions.Mine(ctx, id, voter.ID); err != nil || len(mine) != 1 || mine[0] != ReactionLike {
+		t.Errorf("reactions after a vote replacing laugh = %v, %v; want [like]", mine, err)
+	}
+
+	for _, vote := range []string{ReactionLike, ""} {
+		if _, err := s.Reactions.Vote(ctx, id+1, voter.ID, vote, nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Vote(%q) on a missing comment: err = %v, want ErrNotFound", vote, err)
+		}
+	}
+	if n := votes(t, db, id+1, voter.ID); n != 0 {
+		t.Errorf("vote on a missing comment left %d rows", n)
+	}
+}
+
+func TestAddReaction(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	reader := createUser(t, s, "reader")
+	id := createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+
+	steps := []struct {
+		kind      string
+		replaces  []string
+		wantAdded bool
+		wantMine  []string
+	}{
+		{"love", nil, true, []string{"love"}},
+		{"love", nil, false, []string{"love"}},
+		{"laugh", nil, true, []string{"laugh", "love"}},
+		// One reaction per user: every other kind is replaced.
+		{"wow", []string{"love", "laugh", "wow"}, true, []string{"wow"}},
+		{"wow", []string{"love", "laugh", "wow"}, false, []string{"wow"}},
+	}
+	for _, st := range steps {
+		added, err := s.Reactions.Add(ctx, id, reader.ID, st.kind, st.replaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mine, err := s.Reactions.Mine(ctx, id, reader.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != st.wantAdded || !slices.Equal(mine, st.wantMine) {
+			t.Errorf("Add(%s, %v) = %v, mine %v; want %v, %v", st.kind, st.replaces, added, mine, st.wantAdded, st.wantMine)
+		}
+	}
+
+	if removed, err := s.Reactions.Remove(ctx, id, reader.ID, "wow"); err != nil || !removed {
+		t.Errorf("Remove = %v, %v; want true", removed, err)
+	}
+	if removed, err := s.Reactions.Remove(ctx, id, reader.ID, "wow"); err != nil || removed {
+		t.Errorf("second Remove = %v, %v; want false", removed, err)
+	}
+	if _, err := s.Reactions.Add(ctx, id+1, reader.ID, "love", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Add on a missing comment: err = %v, want ErrNotFound", err)
+	}
+}
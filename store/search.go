@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"time"
+)
+
+// SortRelevance orders search results best match first. It is only valid
+// for Search.
+const SortRelevance Sort = "relevance"
+
+// Markers that snippet() puts around matched terms. cleanBody strips them
+// from comments, so the snippet can be HTML-escaped before they become
+// <mark> tags.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// ErrSearchUnavailable is returned by Search when the binary was built
+// without FTS5 and so has no search index; see EnableSearch.
+var ErrSearchUnavailable = errors.New("store: comment search is unavailable")
+
+// SearchQuery describes a full-text search over comments.
+type SearchQuery struct {
+	// Text is what the user typed. Every word must match; a trailing "*"
+	// matches a prefix.
+	Text string
+	// Pages, AuthorID and Since narrow the results when set.
+	Pages    *PageFilter
+	AuthorID int
+	Since    time.Time
+}
+
+// SearchResult is a matching comment with the matched words highlighted.
+type SearchResult struct {
+	Comment
+	// Snippet is an HTML-escaped excerpt with matches wrapped in <mark>.
+	Snippet string `json:"snippet"`
+}
+
+// ftsQuery turns user input into an FTS5 query that cannot be a syntax
+// error: each word becomes a quoted phrase and the phrases are ANDed.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+func (s *sqliteComments) Search(ctx context.Context, q SearchQuery, viewerID int, page Page) ([]SearchResult, string, error) {
+	if ok, err := hasSearchIndex(ctx, s.db); err != nil || !ok {
+		if err == nil {
+			err = ErrSearchUnavailable
+		}
+		return nil, "", err
+	}
+	match := ftsQuery(q.Text)
+	if match == "" {
+		return nil, "", nil
+	}
+	args := []any{
+		sql.Named("viewer", viewerID), sql.Named("match", match),
+		sql.Named("limit", page.Limit+1),
+	}
+
+	where := []string{"c.deleted_at IS NULL"}
+	if q.Pages != nil {
+		where = append(where, q.Pages.where())
+		args = append(args, sql.Named("page_url", q.Pages.URL))
+	}
+	if q.AuthorID != 0 {
+		where = append(where, "c.user_id = :author")
+		args = append(args, sql.Named("author", q.AuthorID))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "julianday(c.created_at) >= julianday(:since)")
+		args = append(args, sql.Named("since", q.Since.UTC().Format("2006-01-02 15:04:05")))
+	}
+
+	after := "1"
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, SortRelevance)
+		if err != nil {
+			return nil, "", err
+		}
+		after = "(h.score, l.id) > (:after_key, :after_id)"
+		args = append(args, sql.Named("after_key", c.Key), sql.Named("after_id", c.ID))
+	}
+
+	// bm25() is lower for better matches.
+	rows, err := s.db.QueryContext(ctx, `
+		WITH hits AS (
+			SELECT rowid AS id, bm25(comments_fts) AS score,
+			       snippet(comments_fts, 0, '`+matchStart+`', '`+matchEnd+`', '…', 16) AS snippet
+			FROM comments_fts WHERE comments_fts MATCH :match
+		),
+		listing AS (`+commentProjection+`
+			WHERE c.id IN (SELECT id FROM hits) AND `+strings.Join(where, " AND ")+`)
+		SELECT l.*, h.snippet, h.score
+		FROM listing l JOIN hits h ON h.id = l.id
+		WHERE `+after+`
+		ORDER BY h.score, l.id
+		LIMIT :limit`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	var last cursor
+	for rows.Next() {
+		if len(results) == page.Limit {
+			return results, last.encode(), nil
+		}
+		var r SearchResult
+		var score float64
+		if err := scanComment(rows, &r.Comment, &r.Snippet, &score); err != nil {
+			return nil, "", err
+		}
+		r.Snippet = highlight(r.Snippet)
+		last = cursor{Sort: SortRelevance, Key: score, ID: r.ID}
+		results = append(results, r)
+	}
+	return results, "", rows.Err()
+}
+
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, matchStart, "<mark>")
+	return strings.ReplaceAll(snippet, matchEnd, "</mark>")
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello world", `"hello" "world"`},
+		{"  spaced\tout  ", `"spaced" "out"`},
+		{"pre*", `"pre"*`},
+		{"*", ``},
+		{`say "hi"`, `"say" """hi"""`},
+		{"a OR b", `"a" "OR" "b"`},
+		{"col:x NEAR(y)", `"col:x" "NEAR(y)"`},
+		{"", ``},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.in); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a " + matchStart + "match" + matchEnd + " b", "a <mark>match</mark> b"},
+		{"<b>" + matchStart + "x" + matchEnd + "</b>", "&lt;b&gt;<mark>x</mark>&lt;/b&gt;"},
+		{"no match", "no match"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.in); got != tt.want {
+			t.Errorf("highlight(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestCleanBody checks that comments cannot smuggle in the snippet
+// markers, which would let them inject <mark> tags into search results.
+func TestCleanBody(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"tab\tand\r\nnewline", "tab\tand\r\nnewline"},
+		{"a" + matchStart + "fake" + matchEnd + "b", "afakeb"},
+		{"nul\x00bell\x07esc\x1b", "nulbellesc"},
+		{"del\x7f and ü", "del\x7f and ü"},
+	}
+	for _, tt := range tests {
+		if got := cleanBody(tt.in); got != tt.want {
+			t.Errorf("cleanBody(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	id := createComment(t, s, Comment{UserID: author.ID, Username: "author", Comment: "new" + matchStart + "body"})
+	if c, err := s.Comments.Get(ctx, id, 0); err != nil || c.Comment != "newbody" {
+		t.Errorf("created comment = %q, %v; want control characters stripped", c.Comment, err)
+	}
+	if err := s.Comments.Edit(ctx, id, author.ID, "edited"+matchEnd+"body", nil); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := s.Comments.Get(ctx, id, 0); err != nil || c.Comment != "editedbody" {
+		t.Errorf("edited comment = %q, %v; want control characters stripped", c.Comment, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	createComment(t, s, Comment{UserID: author.ID, Username: "author", Comment: "the quick brown fox"})
+	createComment(t, s, Comment{UserID: author.ID, Username: "author", Comment: "a lazy <dog>"})
+
+	page := Page{Sort: SortRelevance, Limit: 10}
+	enabled, err := EnableSearch(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		if _, _, err := s.Comments.Search(ctx, SearchQuery{Text: "fox"}, 0, page); !errors.Is(err, ErrSearchUnavailable) {
+			t.Errorf("Search without FTS5: err = %v, want ErrSearchUnavailable", err)
+		}
+		t.Skip("SQLite was built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	tests := []struct {
+		text        string
+		wantSnippet []string
+	}{
+		{"fox", []string{"the quick brown <mark>fox</mark>"}},
+		{"qui*", []string{"the <mark>quick</mark> brown fox"}},
+		{"dog", []string{"a lazy &lt;<mark>dog</mark>&gt;"}},
+		{"fox dog", nil},
+		{`"`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			results, _, err := s.Comments.Search(ctx, SearchQuery{Text: tt.text}, 0, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.wantSnippet) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.wantSnippet))
+			}
+			for i, r := range results {
+				if r.Snippet != tt.wantSnippet[i] {
+					t.Errorf("snippet = %q, want %q", r.Snippet, tt.wantSnippet[i])
+				}
+			}
+		})
+	}
+}
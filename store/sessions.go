@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SessionStore keeps login sessions. Only a hash of each token is stored, so
+// a leaked database does not leak usable tokens.
+type SessionStore interface {
+	Create(ctx context.Context, tokenHash string, userID int, expiresAt time.Time) error
+	// UserID returns ErrNotFound for unknown or expired tokens.
+	UserID(ctx context.Context, tokenHash string) (int, error)
+	Delete(ctx context.Context, tokenHash string) error
+	DeleteExpired(ctx context.Context) error
+}
+
+type sqliteSessions struct {
+	db *sql.DB
+}
+
+func (s *sqliteSessions) Create(ctx context.Context, tokenHash string, userID int, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, userID, expiresAt.UTC().Truncate(time.Second))
+	return err
+}
+
+func (s *sqliteSessions) UserID(ctx context.Context, tokenHash string) (int, error) {
+	var userID int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id FROM sessions WHERE token_hash = ? AND expires_at > ?`,
+		tokenHash, now()).Scan(&userID)
+	return userID, notFound(err)
+}
+
+func (s *sqliteSessions) Delete(ctx context.Context, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func (s *sqliteSessions) DeleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, now())
+	return err
+}
+
+// now is the current time in the form timestamps are stored, so that SQLite's
+// text comparison orders them correctly.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
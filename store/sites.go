@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PageFilter selects the page URLs a listing covers: either exactly URL, or
+// with Prefix set, URL itself and every URL continuing it with "/" or "?".
+// URLs are compared in their canonical form, so the prefix "https://x.com"
+// covers the whole site and "https://x.com/blog" one section of it.
+type PageFilter struct {
+	URL    string
+	Prefix bool
+}
+
+// where returns the SQL condition on c.url, bound to :page_url. A prefix is
+// matched as two ranges, since '0' and '@' sort right after '/' and '?', so
+// that the index on url is used.
+func (f PageFilter) where() string {
+	if !f.Prefix {
+		return `c.url = :page_url`
+	}
+	return `(c.url = :page_url
+		OR (c.url >= :page_url || '/' AND c.url < :page_url || '0')
+		OR (c.url >= :page_url || '?' AND c.url < :page_url || '@'))`
+}
+
+// PageStat counts the discussion on one page.
+type PageStat struct {
+	URL             string `json:"url"`
+	CommentCount    int    `json:"comment_count"`
+	LatestCommentAt string `json:"latest_comment_at"`
+}
+
+// SiteStats summarizes the discussion on a group of pages.
+type SiteStats struct {
+	PageCount    int `json:"page_count"`
+	CommentCount int `json:"comment_count"`
+	// Pages lists the most discussed pages, at most the number asked for.
+	Pages []PageStat `json:"pages"`
+}
+
+func (s *sqliteComments) SiteStats(ctx context.Context, filter PageFilter, topPages int) (SiteStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.url, COUNT(*), strftime('%Y-%m-%dT%H:%M:%SZ', MAX(c.created_at)),
+		       COUNT(*) OVER (), SUM(COUNT(*)) OVER ()
+		FROM comments c
+		WHERE `+filter.where()+` AND c.deleted_at IS NULL
+		GROUP BY c.url
+		ORDER BY COUNT(*) DESC, c.url
+		LIMIT :limit`,
+		sql.Named("page_url", filter.URL), sql.Named("limit", topPages))
+	if err != nil {
+		return SiteStats{}, err
+	}
+	defer rows.Close()
+
+	stats := SiteStats{Pages: []PageStat{}}
+	for rows.Next() {
+		var p PageStat
+		if err := rows.Scan(&p.URL, &p.CommentCount, &p.LatestCommentAt, &stats.PageCount, &stats.CommentCount); err != nil {
+			return SiteStats{}, err
+		}
+		stats.Pages = append(stats.Pages, p)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSiteStats(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+
+	comments := map[string]int{
+		"https://x.com/":               3,
+		"https://x.com/blog":           1,
+		"https://x.com/blog/post?id=1": 2,
+		"https://x.com/blog?page=2":    1,
+		"https://x.com/blogger":        1,
+		"https://x.com.evil.example/":  1,
+		"https://x.company/blog":       1,
+		"http://x.com/blog/post?id=1":  1,
+		"https://other.example/x.com/": 1,
+	}
+	for url, n := range comments {
+		for range n {
+			createComment(t, s, Comment{UserID: alice.ID, URL: url})
+		}
+	}
+	// Deleted comments are not counted.
+	deleted := createComment(t, s, Comment{UserID: alice.ID, URL: "https://x.com/blog"})
+	if err := s.Comments.SoftDelete(ctx, deleted, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		filter        PageFilter
+		topPages      int
+		wantPages     []string
+		wantPageCount int
+		wantComments  int
+	}{
+		{"site", PageFilter{URL: "https://x.com", Prefix: true}, 10,
+			[]string{"https://x.com/", "https://x.com/blog/post?id=1", "https://x.com/blog", "https://x.com/blog?page=2", "https://x.com/blogger"}, 5, 8},
+		{"top pages", PageFilter{URL: "https://x.com", Prefix: true}, 2,
+			[]string{"https://x.com/", "https://x.com/blog/post?id=1"}, 5, 8},
+		{"section", PageFilter{URL: "https://x.com/blog", Prefix: true}, 10,
+			[]string{"https://x.com/blog/post?id=1", "https://x.com/blog", "https://x.com/blog?page=2"}, 3, 4},
+		{"page", PageFilter{URL: "https://x.com/blog"}, 10, []string{"https://x.com/blog"}, 1, 1},
+		{"no comments", PageFilter{URL: "https://y.com", Prefix: true}, 10, []string{}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := s.Comments.SiteStats(ctx, tt.filter, tt.topPages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, p := range stats.Pages {
+				got = append(got, p.URL)
+				if p.CommentCount != comments[p.URL] || p.LatestCommentAt == "" {
+					t.Errorf("page %+v, want %d comments", p, comments[p.URL])
+				}
+			}
+			if !slices.Equal(got, tt.wantPages) || stats.PageCount != tt.wantPageCount || stats.CommentCount != tt.wantComments {
+				t.Errorf("SiteStats = %d pages, %d comments, top %q; want %d, %d, %q",
+					stats.PageCount, stats.CommentCount, got, tt.wantPageCount, tt.wantComments, tt.wantPages)
+			}
+
+			listed, _, err := s.Comments.ListTopLevel(ctx, tt.filter, 0, Page{Sort: SortOldest, Limit: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != tt.wantComments {
+				t.Errorf("ListTopLevel returned %d comments, want %d", len(listed), tt.wantComments)
+			}
+		})
+	}
+}
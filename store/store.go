@@ -0,0 +1,82 @@
+// Package store is the persistence layer behind the HTTP handlers. Each
+// repository is an interface so handlers can be exercised against another
+// backend; NewSQLite wires up the SQLite implementation.
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches no row.
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when a write violates a uniqueness constraint.
+	ErrConflict = errors.New("store: conflict")
+	// ErrForbidden is returned when a user changes a row they do not own.
+	ErrForbidden = errors.New("store: forbidden")
+)
+
+// Store groups the repositories used by the server.
+type Store struct {
+	Comments      CommentStore
+	Users         UserStore
+	Sessions      SessionStore
+	Identities    IdentityStore
+	Profiles      ProfileStore
+	Reactions     ReactionStore
+	Connections   ConnectionStore
+	Summaries     SummaryStore
+	Trending      TrendingStore
+	Notifications NotificationStore
+	Webhooks      WebhookStore
+	Mentions      MentionStore
+	Badges        BadgeStore
+}
+
+// NewSQLite returns a Store backed by db.
+func NewSQLite(db *sql.DB) *Store {
+	return &Store{
+		Comments:      &sqliteComments{db: db},
+		Users:         &sqliteUsers{db: db},
+		Sessions:      &sqliteSessions{db: db},
+		Identities:    &sqliteIdentities{db: db},
+		Profiles:      &sqliteProfiles{db: db},
+		Reactions:     &sqliteReactions{db: db},
+		Connections:   &sqliteConnections{db: db},
+		Summaries:     &sqliteSummaries{db: db},
+		Trending:      &sqliteTrending{db: db},
+		Notifications: &sqliteNotifications{db: db},
+		Webhooks:      &sqliteWebhooks{db: db},
+		Mentions:      &sqliteMentions{db: db},
+		Badges:        &sqliteBadges{db: db},
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func conflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrConflict
+	}
+	return err
+}
+
+// newPublicID returns a time-ordered UUIDv7 for exposing rows outside the
+// server without leaking their sequential IDs.
+func newPublicID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
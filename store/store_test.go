@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// newTestStore returns a Store over a fresh, fully migrated database.
+func newTestStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := MigrateUp(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLite(db), db
+}
+
+func createUser(t *testing.T, s *Store, username string) User {
+	t.Helper()
+	u := User{Username: username}
+	if err := s.Users.Create(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func createComment(t *testing.T, s *Store, c Comment) int {
+	t.Helper()
+	if c.URL == "" {
+		c.URL = "https://example.com/"
+	}
+	if c.Comment == "" {
+		c.Comment = "hello"
+	}
+	id, err := s.Comments.Create(context.Background(), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestUsers(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	if alice.ID == 0 || alice.PublicID == "" {
+		t.Fatalf("Create did not fill in IDs: %+v", alice)
+	}
+
+	if err := s.Users.Create(ctx, &User{Username: "alice"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("duplicate Create: err = %v, want ErrConflict", err)
+	}
+
+	tests := []struct {
+		name    string
+		get     func() (User, error)
+		wantErr error
+	}{
+		{"by id", func() (User, error) { return s.Users.ByID(ctx, alice.ID) }, nil},
+		{"by username", func() (User, error) { return s.Users.ByUsername(ctx, "alice") }, nil},
+		{"by public id", func() (User, error) { return s.Users.ByPublicID(ctx, alice.PublicID) }, nil},
+		{"unknown id", func() (User, error) { return s.Users.ByID(ctx, alice.ID+1) }, ErrNotFound},
+		{"unknown username", func() (User, error) { return s.Users.ByUsername(ctx, "bob") }, ErrNotFound},
+		{"username is case sensitive", func() (User, error) { return s.Users.ByUsername(ctx, "Alice") }, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := tt.get()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (u.ID != alice.ID || u.PublicID != alice.PublicID || u.Username != "alice") {
+				t.Errorf("got %+v, want %+v", u, alice)
+			}
+		})
+	}
+
+	if err := s.Users.SetPassword(ctx, alice.ID, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.Users.ByID(ctx, alice.ID); u.PasswordHash != "hash" {
+		t.Errorf("PasswordHash = %q after SetPassword", u.PasswordHash)
+	}
+}
+
+func TestCommentLifecycle(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	id := createComment(t, s, Comment{UserID: alice.ID, Username: "alice", Comment: "first *draft*"})
+	reply := createComment(t, s, Comment{UserID: bob.ID, Username: "bob", ParentID: &id})
+
+	c, err := s.Comments.Get(ctx, id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UserPublicID != alice.PublicID || c.ReplyCount != 1 || c.CommentHTML != "<p>first <em>draft</em></p>\n" {
+		t.Errorf("Get = %+v", c)
+	}
+
+	edits := []struct {
+		name     string
+		authorID int
+		wantErr  error
+	}{
+		{"other user", bob.ID, ErrForbidden},
+		{"author", alice.ID, nil},
+	}
+	for _, e := range edits {
+		t.Run("edit by "+e.name, func(t *testing.T) {
+			err := s.Comments.Edit(ctx, id, e.authorID, "second draft", nil)
+			if !errors.Is(err, e.wantErr) {
+				t.Fatalf("err = %v, want %v", err, e.wantErr)
+			}
+		})
+	}
+	revisions, err := s.Comments.Revisions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Comment != "first *draft*" {
+		t.Errorf("Revisions = %+v, want the first draft", revisions)
+	}
+
+	if err := s.Comments.SoftDelete(ctx, id, bob.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("SoftDelete by other user: err = %v, want ErrForbidden", err)
+	}
+	if err := s.Comments.SoftDelete(ctx, id, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	c, err = s.Comments.Get(ctx, id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Deleted || c.Comment != DeletedBody || c.UserID != 0 {
+		t.Errorf("soft-deleted comment = %+v", c)
+	}
+
+	// The deleted comment stays listed while it has a reply.
+	top, _, err := s.Comments.ListTopLevel(ctx, PageFilter{URL: "https://example.com/"}, 0, Page{Sort: SortOldest, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0].ID != id {
+		t.Errorf("ListTopLevel = %+v, want the deleted parent", top)
+	}
+
+	purged, err := s.Comments.Purge(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("Purge removed %d comments, want 2", purged)
+	}
+	for _, gone := range []int{id, reply} {
+		if _, err := s.Comments.Get(ctx, gone, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%d) after Purge: err = %v, want ErrNotFound", gone, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SummaryStore caches the ML-generated summary of each URL's discussion.
+type SummaryStore interface {
+	// Get returns ErrNotFound if url has no cached summary.
+	Get(ctx context.Context, url string) (string, error)
+	Put(ctx context.Context, url, summary string) error
+}
+
+type sqliteSummaries struct {
+	db *sql.DB
+}
+
+func (s *sqliteSummaries) Get(ctx context.Context, url string) (string, error) {
+	var summary string
+	err := s.db.QueryRowContext(ctx, `SELECT summary FROM summaries WHERE url = ?`, url).Scan(&summary)
+	return summary, notFound(err)
+}
+
+func (s *sqliteSummaries) Put(ctx context.Context, url, summary string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO summaries (url, summary) VALUES (?, ?)
+		ON CONFLICT(url) DO UPDATE SET summary = excluded.summary`,
+		url, summary)
+	return err
+}
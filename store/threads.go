@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// ThreadNode is a comment with the replies below it.
+type ThreadNode struct {
+	Comment
+	Replies []*ThreadNode `json:"replies"`
+	// NextCursor is set when the comment has more replies than the page
+	// limit; pass it to ListReplies with the same sort to fetch the rest.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Thread returns the comment rootID with its replies nested depth levels
+// deep. Each comment carries at most page.Limit replies in page.Sort order.
+// Replies below the last level are only reflected in ReplyCount.
+//
+// The thread is read one level at a time, so only the replies to comments
+// that made the cut are loaded, however large the whole subtree is.
+func (s *sqliteComments) Thread(ctx context.Context, rootID, viewerID, depth int, page Page) (*ThreadNode, error) {
+	key, ok := sortKeys[page.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", page.Sort)
+	}
+	dir := "DESC"
+	if key.asc {
+		dir = "ASC"
+	}
+
+	roots, err := s.query(ctx, `WHERE c.id = :root`, sql.Named("viewer", viewerID), sql.Named("root", rootID))
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, ErrNotFound
+	}
+	root := &ThreadNode{Comment: roots[0], Replies: []*ThreadNode{}}
+
+	level := map[int]*ThreadNode{root.ID: root}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		parentIDs := make([]int, 0, len(level))
+		for id, n := range level {
+			if n.ReplyCount > 0 {
+				parentIDs = append(parentIDs, id)
+			}
+		}
+		if len(parentIDs) == 0 {
+			break
+		}
+		parents, err := json.Marshal(parentIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fetching one reply past the limit per parent tells whether it needs
+		// a cursor.
+		rows, err := s.db.QueryContext(ctx, `
+			WITH listing AS (`+commentProjection+`
+				WHERE c.parent_id IN (SELECT value FROM json_each(:parents)) AND `+listedComment+`),
+			keyed AS (SELECT *, `+key.expr+` AS sort_key FROM listing),
+			ranked AS (
+				SELECT *, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY sort_key `+dir+`, id `+dir+`) AS sibling_rank
+				FROM keyed
+			)
+			SELECT * FROM ranked
+			WHERE sibling_rank <= :limit + 1
+			ORDER BY parent_id, sibling_rank`,
+			sql.Named("viewer", viewerID), sql.Named("parents", string(parents)),
+			sql.Named("limit", page.Limit))
+		if err != nil {
+			return nil, err
+		}
+
+		next := make(map[int]*ThreadNode)
+		last := make(map[int]cursor)
+		for rows.Next() {
+			n := &ThreadNode{Replies: []*ThreadNode{}}
+			var sortKey float64
+			var rank int
+			if err := scanComment(rows, &n.Comment, &sortKey, &rank); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			parent := level[*n.ParentID]
+			if rank > page.Limit {
+				parent.NextCursor = last[parent.ID].encode()
+				continue
+			}
+			parent.Replies = append(parent.Replies, n)
+			last[parent.ID] = cursor{Sort: page.Sort, Key: sortKey, ID: n.ID}
+			next[n.ID] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		level = next
+	}
+	return root, nil
+}
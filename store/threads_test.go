@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestThread(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	reply := func(parent int) int {
+		return createComment(t, s, Comment{UserID: author.ID, Username: "author", ParentID: &parent})
+	}
+
+	// root
+	// ├── a (3 replies: a1 with a reply of its own, a2, a3)
+	// ├── b
+	// └── c
+	root := createComment(t, s, Comment{UserID: author.ID, Username: "author"})
+	a, b, c := reply(root), reply(root), reply(root)
+	a1, a2, a3 := reply(a), reply(a), reply(a)
+	a1x := reply(a1)
+
+	ids := func(nodes []*ThreadNode) []int {
+		var out []int
+		for _, n := range nodes {
+			out = append(out, n.ID)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		depth int
+		limit int
+		// want maps each comment to the IDs of its returned replies.
+		want     map[int][]int
+		withNext []int
+	}{
+		{
+			name: "everything", depth: 3, limit: 10,
+			want: map[int][]int{root: {a, b, c}, a: {a1, a2, a3}, a1: {a1x}},
+		},
+		{
+			name: "depth cuts deeper replies", depth: 1, limit: 10,
+			want: map[int][]int{root: {a, b, c}},
+		},
+		{
+			name: "limit per parent", depth: 3, limit: 2,
+			want:     map[int][]int{root: {a, b}, a: {a1, a2}, a1: {a1x}},
+			withNext: []int{root, a},
+		},
+		{
+			name: "zero depth", depth: 0, limit: 10,
+			want: map[int][]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := s.Comments.Thread(ctx, root, 0, tt.depth, Page{Sort: SortOldest, Limit: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var walk func(n *ThreadNode)
+			walk = func(n *ThreadNode) {
+				if got := ids(n.Replies); !slices.Equal(got, tt.want[n.ID]) {
+					t.Errorf("replies of %d = %v, want %v", n.ID, got, tt.want[n.ID])
+				}
+				if hasNext := n.NextCursor != ""; hasNext != slices.Contains(tt.withNext, n.ID) {
+					t.Errorf("comment %d: NextCursor = %q", n.ID, n.NextCursor)
+				}
+				for _, r := range n.Replies {
+					walk(r)
+				}
+			}
+			walk(tree)
+		})
+	}
+
+	// A node's cursor continues its replies through ListReplies.
+	tree, err := s.Comments.Thread(ctx, root, 0, 2, Page{Sort: SortOldest, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, _, err := s.Comments.ListReplies(ctx, a, 0, Page{Sort: SortOldest, Limit: 10, Cursor: tree.Replies[0].NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].ID != a3 {
+		t.Errorf("replies after the thread's cursor = %+v, want a3", rest)
+	}
+
+	if _, err := s.Comments.Thread(ctx, a1x+1, 0, 2, Page{Sort: SortOldest, Limit: 2}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Thread of a missing comment: err = %v, want ErrNotFound", err)
+	}
+}
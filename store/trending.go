@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// TrendWindows maps each trending window to the half-life of the activity
+// counted in it.
+var TrendWindows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// Weights of the activity that makes a page trend.
+const (
+	commentWeight = 1.0
+	likeWeight    = 0.5
+)
+
+// TrendingPage is a page ranked by recent activity.
+type TrendingPage struct {
+	URL string `json:"url"`
+	// Score is the exponentially decayed sum of comment and like weights.
+	Score        float64 `json:"score"`
+	CommentCount int     `json:"comment_count"`
+}
+
+// TrendingStore ranks pages by recent activity. Scores are updated as
+// comments and likes arrive, so ranking never scans the comments.
+type TrendingStore interface {
+	// Top returns up to limit pages with the most activity in window, which
+	// must be a key of TrendWindows.
+	Top(ctx context.Context, window string, limit int) ([]TrendingPage, error)
+}
+
+type sqliteTrending struct {
+	db *sql.DB
+}
+
+// Each page keeps log2 of its decayed score scaled to the Unix epoch: an
+// event of weight w at time t adds 2^(t/halfLife) * w. Scaling every score by
+// the same factor keeps their order, so ranking needs no update over time,
+// and the log keeps the numbers finite.
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// recordActivity adds an event of weight at time at to url's scores.
+func recordActivity(ctx context.Context, db execer, url string, weight float64, at time.Time) error {
+	for window, halfLife := range TrendWindows {
+		logScore := math.Log2(weight) + float64(at.Unix())/halfLife.Seconds()
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO page_trending (url, period, log_score) VALUES (?, ?, ?)
+			ON CONFLICT (url, period) DO UPDATE SET log_score = log2_add(log_score, excluded.log_score)`,
+			url, window, logScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshHot recomputes the stored hot_rank of a comment after its votes
+// change.
+func refreshHot(ctx context.Context, db execer, commentID int) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE comments SET hot_score = hot_rank(
+			(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = :id AND kind = 'like') -
+			(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = :id AND kind = 'dislike'),
+			CAST(strftime('%s', created_at) AS INTEGER))
+		WHERE id = :id`,
+		sql.Named("id", commentID))
+	return err
+}
+
+// Scores below 2^-30 are noise and left out of the ranking.
+const minTrendingLogScore = -30
+
+func (s *sqliteTrending) Top(ctx context.Context, window string, limit int) ([]TrendingPage, error) {
+	halfLife := TrendWindows[window]
+	now := float64(time.Now().Unix()) / halfLife.Seconds()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT t.url, t.log_score, (SELECT COUNT(*) FROM comments c WHERE c.url = t.url AND c.deleted_at IS NULL)
+		FROM page_trending t
+		WHERE t.period = ? AND t.log_score > ?
+		ORDER BY t.log_score DESC
+		LIMIT ?`,
+		window, now+minTrendingLogScore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []TrendingPage{}
+	for rows.Next() {
+		var p TrendingPage
+		var logScore float64
+		if err := rows.Scan(&p.URL, &logScore, &p.CommentCount); err != nil {
+			return nil, err
+		}
+		p.Score = math.Exp2(logScore - now)
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// backfillActivity seeds hot_score and page_trending from existing rows.
+// Likes have no timestamp, so they count as of their comment's creation. It
+// runs before likes moved to comment_reactions and reads the old table.
+func backfillActivity(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE comments SET hot_score = hot_rank(
+			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = comments.id AND cl.is_like = 1) -
+			(SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = comments.id AND cl.is_like = 0),
+			CAST(strftime('%s', created_at) AS INTEGER))`); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT url, CAST(strftime('%s', created_at) AS INTEGER),
+		       (SELECT COUNT(*) FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.is_like = 1)
+		FROM comments c`)
+	if err != nil {
+		return err
+	}
+	type activity struct {
+		url   string
+		at    int64
+		likes int
+	}
+	var all []activity
+	for rows.Next() {
+		var a activity
+		if err := rows.Scan(&a.url, &a.at, &a.likes); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range all {
+		weight := commentWeight + likeWeight*float64(a.likes)
+		if err := recordActivity(ctx, tx, a.url, weight, time.Unix(a.at, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHotRank(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	if got, want := hotRank(10, at), hotRank(1, at+45000); math.Abs(got-want) > 1e-9 {
+		t.Errorf("ten votes = %v, want the same as one vote 45000s later (%v)", got, want)
+	}
+	if !(hotRank(-10, at) < hotRank(-1, at) && hotRank(-1, at) == hotRank(0, at) && hotRank(0, at) < hotRank(2, at)) {
+		t.Error("hotRank does not order by net votes")
+	}
+	if hotRank(0, at) >= hotRank(0, at+1) {
+		t.Error("hotRank does not favour newer comments")
+	}
+}
+
+// TestHotOrdering checks that votes keep the stored hot_score current and
+// that the hot sort follows it.
+func TestHotOrdering(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	var voters []User
+	for _, name := range []string{"v1", "v2", "v3"} {
+		voters = append(voters, createUser(t, s, name))
+	}
+
+	quiet := createComment(t, s, Comment{UserID: author.ID})
+	liked := createComment(t, s, Comment{UserID: author.ID})
+	disliked := createComment(t, s, Comment{UserID: author.ID})
+	undone := createComment(t, s, Comment{UserID: author.ID})
+	for _, v := range voters {
+		if _, err := s.Reactions.Vote(ctx, liked, v.ID, ReactionLike, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Reactions.Add(ctx, undone, v.ID, ReactionLike, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range voters[:2] {
+		if _, err := s.Reactions.Vote(ctx, disliked, v.ID, ReactionDislike, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range voters {
+		if _, err := s.Reactions.Remove(ctx, undone, v.ID, ReactionLike); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, net := range map[int]int64{quiet: 0, liked: 3, disliked: -2, undone: 0} {
+		var score float64
+		var created int64
+		if err := db.QueryRow(`SELECT hot_score, CAST(strftime('%s', created_at) AS INTEGER) FROM comments WHERE id = ?`,
+			id).Scan(&score, &created); err != nil {
+			t.Fatal(err)
+		}
+		if want := hotRank(net, created); score != want {
+			t.Errorf("comment %d: hot_score = %v, want %v for %d net votes", id, score, want, net)
+		}
+	}
+
+	comments, _, err := s.Comments.ListTopLevel(ctx, PageFilter{URL: "https://example.com/"}, 0, Page{Sort: SortHot, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, c := range comments {
+		got = append(got, c.ID)
+	}
+	// quiet and undone tie, so the higher id comes first.
+	want := []int{liked, undone, quiet, disliked}
+	if len(got) != len(want) {
+		t.Fatalf("hot listing = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("hot listing = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTrendingTop(t *testing.T) {
+	s, db := newTestStore(t)
+	ctx := context.Background()
+	author := createUser(t, s, "author")
+	voter := createUser(t, s, "voter")
+
+	// A new comment and a like on it.
+	const busy = "https://busy.example/"
+	id := createComment(t, s, Comment{UserID: author.ID, URL: busy})
+	if _, err := s.Reactions.Vote(ctx, id, voter.ID, ReactionLike, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Two events an hour apart, the last one two hours ago.
+	const cooling = "https://cooling.example/"
+	for _, ago := range []time.Duration{3 * time.Hour, 2 * time.Hour} {
+		if err := recordActivity(ctx, db, cooling, commentWeight, time.Now().Add(-ago)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Activity from three days ago.
+	const stale = "https://stale.example/"
+	if err := recordActivity(ctx, db, stale, commentWeight, time.Now().Add(-72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		url      string
+		score    float64
+		comments int
+	}
+	tests := []struct {
+		window string
+		limit  int
+		want   []want
+	}{
+		{"1h", 10, []want{{busy, 1.5, 1}, {cooling, 0.25 + 0.125, 0}}},
+		{"1h", 1, []want{{busy, 1.5, 1}}},
+		// Over a day the older page has decayed less than its two events
+		// outweigh.
+		{"24h", 10, []want{{cooling, math.Exp2(-2.0/24) + math.Exp2(-3.0/24), 0}, {busy, 1.5, 1}, {stale, 0.125, 0}}},
+	}
+	for _, tt := range tests {
+		pages, err := s.Trending.Top(ctx, tt.window, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != len(tt.want) {
+			t.Errorf("%s limit %d: got %+v, want %+v", tt.window, tt.limit, pages, tt.want)
+			continue
+		}
+		for i, p := range pages {
+			w := tt.want[i]
+			if p.URL != w.url || math.Abs(p.Score-w.score) > 1e-3 || p.CommentCount != w.comments {
+				t.Errorf("%s limit %d: page %d = %+v, want %+v", tt.window, tt.limit, i, p, w)
+			}
+		}
+	}
+}
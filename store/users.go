@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type User struct {
+	ID       int    `json:"user_id"`
+	PublicID string `json:"public_id"`
+	Username string `json:"username"`
+	// PasswordHash is empty for accounts created before passwords existed.
+	PasswordHash string `json:"-"`
+	// IsAdmin allows moderation actions such as purging comments.
+	IsAdmin bool `json:"-"`
+	// Karma is the user's karma when they were loaded, for moderation
+	// thresholds.
+	Karma float64 `json:"-"`
+}
+
+type UserStore interface {
+	// ByID and ByUsername return ErrNotFound if no user matches.
+	ByID(ctx context.Context, id int) (User, error)
+	ByUsername(ctx context.Context, username string) (User, error)
+	ByPublicID(ctx context.Context, publicID string) (User, error)
+	// Create inserts u, filling in its ID and PublicID. It returns
+	// ErrConflict if the username is taken.
+	Create(ctx context.Context, u *User) error
+	SetPassword(ctx context.Context, id int, passwordHash string) error
+	// CreateClaim stores a one-time token that lets the owner of a
+	// passwordless account set its password; see Claim.
+	CreateClaim(ctx context.Context, tokenHash string, userID int, expiresAt time.Time) error
+	// Claim redeems a claim token, setting the password of its account and
+	// discarding the account's other claim tokens. It returns ErrNotFound for
+	// unknown, used or expired tokens and ErrConflict if the account has a
+	// password by now.
+	Claim(ctx context.Context, tokenHash, passwordHash string) (User, error)
+	SetAdmin(ctx context.Context, id int, isAdmin bool) error
+	// Suggest returns up to limit users whose username starts with prefix,
+	// ignoring ASCII case. Users in preferred come first.
+	Suggest(ctx context.Context, prefix string, preferred []int, limit int) ([]UserSuggestion, error)
+}
+
+// UserSuggestion is a user offered when completing an @mention.
+type UserSuggestion struct {
+	ID          int    `json:"user_id"`
+	PublicID    string `json:"public_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Connected   bool   `json:"connected"`
+}
+
+type sqliteUsers struct {
+	db *sql.DB
+}
+
+func (s *sqliteUsers) get(ctx context.Context, where string, arg any) (User, error) {
+	var u User
+	var hash sql.NullString
+	var karma float64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, public_id, username, password_hash, is_admin, karma FROM users WHERE `+where, arg).
+		Scan(&u.ID, &u.PublicID, &u.Username, &hash, &u.IsAdmin, &karma)
+	u.PasswordHash = hash.String
+	u.Karma = KarmaAt(karma, time.Now())
+	return u, notFound(err)
+}
+
+func (s *sqliteUsers) ByID(ctx context.Context, id int) (User, error) {
+	return s.get(ctx, "id = ?", id)
+}
+
+func (s *sqliteUsers) ByUsername(ctx context.Context, username string) (User, error) {
+	return s.get(ctx, "username = ?", username)
+}
+
+func (s *sqliteUsers) ByPublicID(ctx context.Context, publicID string) (User, error) {
+	return s.get(ctx, "public_id = ?", publicID)
+}
+
+func (s *sqliteUsers) Create(ctx context.Context, u *User) error {
+	publicID, err := newPublicID()
+	if err != nil {
+		return err
+	}
+	// Leaving id out lets SQLite assign the next rowid, which cannot collide.
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (public_id, username, password_hash) VALUES (?, ?, NULLIF(?, ''))`,
+		publicID, u.Username, u.PasswordHash)
+	if err != nil {
+		return conflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	u.ID = int(id)
+	u.PublicID = publicID
+	return nil
+}
+
+func (s *sqliteUsers) SetPassword(ctx context.Context, id int, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	return err
+}
+
+func (s *sqliteUsers) CreateClaim(ctx context.Context, tokenHash string, userID int, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO claim_tokens (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, userID, expiresAt.UTC().Truncate(time.Second))
+	return err
+}
+
+func (s *sqliteUsers) Claim(ctx context.Context, tokenHash, passwordHash string) (User, error) {
+	var userID int
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id FROM claim_tokens WHERE token_hash = ? AND expires_at > ?`,
+			tokenHash, now()).Scan(&userID)
+		if err != nil {
+			return notFound(err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM claim_tokens WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx,
+			`UPDATE users SET password_hash = ? WHERE id = ? AND password_hash IS NULL`, passwordHash, userID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrConflict
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return s.ByID(ctx, userID)
+}
+
+func (s *sqliteUsers) Suggest(ctx context.Context, prefix string, preferred []int, limit int) ([]UserSuggestion, error) {
+	if preferred == nil {
+		preferred = []int{}
+	}
+	ids, err := json.Marshal(preferred)
+	if err != nil {
+		return nil, err
+	}
+
+	// The range on username COLLATE NOCASE uses users_username_nocase;
+	// U+10FFFF sorts after any character that can follow the prefix.
+	rows, err := s.db.QueryContext(ctx, `
+		WITH matches AS (
+			SELECT id, public_id, username FROM users
+			WHERE username COLLATE NOCASE >= :lo AND username COLLATE NOCASE < :hi
+		),
+		connected AS (
+			SELECT m.*, 1 AS connected FROM matches m
+			WHERE m.id IN (SELECT value FROM json_each(:preferred))
+		),
+		others AS (
+			SELECT m.*, 0 AS connected FROM matches m
+			WHERE m.id NOT IN (SELECT value FROM json_each(:preferred))
+			ORDER BY m.username COLLATE NOCASE
+			LIMIT :limit
+		),
+		picked AS (
+			SELECT * FROM connected UNION ALL SELECT * FROM others
+		)
+		SELECT p.id, p.public_id, p.username, COALESCE(pr.display_name, p.username), COALESCE(pr.avatar_url, ''), p.connected
+		FROM picked p LEFT JOIN profiles pr ON pr.user_id = p.id
+		ORDER BY p.connected DESC, p.username COLLATE NOCASE
+		LIMIT :limit`,
+		sql.Named("lo", prefix), sql.Named("hi", prefix+"\U0010FFFF"),
+		sql.Named("preferred", string(ids)), sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []UserSuggestion{}
+	for rows.Next() {
+		var u UserSuggestion
+		if err := rows.Scan(&u.ID, &u.PublicID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Connected); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, u)
+	}
+	return suggestions, rows.Err()
+}
+
+func (s *sqliteUsers) SetAdmin(ctx context.Context, id int, isAdmin bool) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET is_admin = ? WHERE id = ?`, isAdmin, id)
+	return err
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Webhook scopes.
+const (
+	WebhookGlobal = "global"
+	WebhookDomain = "domain"
+	WebhookPage   = "page"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint that receives comment events. Match is the
+// canonical page URL for page scope, the host for domain scope and empty for
+// global scope.
+type Webhook struct {
+	ID        int      `json:"id"`
+	TargetURL string   `json:"target_url"`
+	Scope     string   `json:"scope"`
+	Match     string   `json:"match,omitempty"`
+	Events    []string `json:"events"`
+	// Secret signs every payload; it is only shown when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is one event queued for one webhook, with the outcome of its
+// latest attempt.
+type Delivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	// RedeliveryOf is the delivery this one repeats.
+	RedeliveryOf *int `json:"redelivery_of,omitempty"`
+
+	// TargetURL and Secret are copied from the webhook by Due.
+	TargetURL string `json:"-"`
+	Secret    string `json:"-"`
+}
+
+// WebhookStore keeps webhook registrations and their delivery log.
+type WebhookStore interface {
+	Create(ctx context.Context, w *Webhook) error
+	List(ctx context.Context) ([]Webhook, error)
+	// Delete removes a webhook and its delivery log.
+	Delete(ctx context.Context, id int) error
+	// Matching returns the webhooks subscribed to event on a page of host.
+	Matching(ctx context.Context, event, pageURL, host string) ([]Webhook, error)
+
+	// Enqueue schedules payload for immediate delivery to webhookID.
+	Enqueue(ctx context.Context, webhookID int, event string, payload []byte) error
+	// Due returns up to limit pending deliveries whose next attempt is due.
+	Due(ctx context.Context, limit int) ([]Delivery, error)
+	// Succeeded and Retry record the outcome of an attempt. A nil retryAt
+	// gives up and marks the delivery failed.
+	Succeeded(ctx context.Context, id, statusCode int) error
+	Retry(ctx context.Context, id int, statusCode *int, errMsg string, retryAt *time.Time) error
+	// Deliveries returns the latest deliveries to webhookID, newest first.
+	Deliveries(ctx context.Context, webhookID, limit int) ([]Delivery, error)
+	// Redeliver queues a copy of a delivery and returns the new ID.
+	Redeliver(ctx context.Context, id int) (int, error)
+}
+
+type sqliteWebhooks struct {
+	db *sql.DB
+}
+
+func (s *sqliteWebhooks) Create(ctx context.Context, w *Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (target_url, scope, match, events, secret) VALUES (?, ?, ?, ?, ?)`,
+		w.TargetURL, w.Scope, w.Match, string(events), w.Secret)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	w.ID = int(id)
+	return s.db.QueryRowContext(ctx, `SELECT created_at FROM webhooks WHERE id = ?`, id).Scan(&w.CreatedAt)
+}
+
+const webhookColumns = `w.id, w.target_url, w.scope, w.match, w.events, w.created_at`
+
+func scanWebhooks(rows *sql.Rows) ([]Webhook, error) {
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.TargetURL, &w.Scope, &w.Match, &events, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &w.Events); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqliteWebhooks) List(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks w ORDER BY w.id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+func (s *sqliteWebhooks) Delete(ctx context.Context, id int) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id)
+		return err
+	})
+}
+
+func (s *sqliteWebhooks) Matching(ctx context.Context, event, pageURL, host string) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+webhookColumns+` FROM webhooks w
+		WHERE EXISTS (SELECT 1 FROM json_each(w.events) WHERE value = :event)
+		  AND (w.scope = 'global'
+		       OR (w.scope = 'domain' AND w.match = :host)
+		       OR (w.scope = 'page' AND w.match = :url))`,
+		sql.Named("event", event), sql.Named("host", host), sql.Named("url", pageURL))
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+func (s *sqliteWebhooks) Enqueue(ctx context.Context, webhookID int, event string, payload []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		webhookID, event, string(payload), now(), now())
+	return err
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_status_code, d.last_error, d.created_at, d.delivered_at, d.redelivery_of`
+
+func scanDelivery(rows *sql.Rows, d *Delivery, extra ...any) error {
+	var payload string
+	var lastError sql.NullString
+	dest := []any{&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &lastError, &d.CreatedAt, &d.DeliveredAt, &d.RedeliveryOf}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	d.Payload = json.RawMessage(payload)
+	d.LastError = lastError.String
+	return nil
+}
+
+func (s *sqliteWebhooks) Due(ctx context.Context, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+`, w.target_url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT ?`, now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := scanDelivery(rows, &d, &d.TargetURL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqliteWebhooks) Succeeded(ctx context.Context, id, statusCode int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'succeeded', attempts = attempts + 1, last_status_code = ?, last_error = NULL,
+		    next_attempt_at = NULL, delivered_at = ?
+		WHERE id = ?`, statusCode, now(), id)
+	return err
+}
+
+func (s *sqliteWebhooks) Retry(ctx context.Context, id int, statusCode *int, errMsg string, retryAt *time.Time) error {
+	status := DeliveryPending
+	if retryAt == nil {
+		status = DeliveryFailed
+	} else {
+		t := retryAt.UTC().Truncate(time.Second)
+		retryAt = &t
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = attempts + 1, last_status_code = ?, last_error = ?, next_attempt_at = ?
+		WHERE id = ?`, status, statusCode, errMsg, retryAt, id)
+	return err
+}
+
+func (s *sqliteWebhooks) Deliveries(ctx context.Context, webhookID, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		WHERE d.webhook_id = ?
+		ORDER BY d.id DESC
+		LIMIT ?`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqliteWebhooks) Redeliver(ctx context.Context, id int) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at, created_at, redelivery_of)
+		SELECT webhook_id, event, payload, ?, ?, id FROM webhook_deliveries WHERE id = ?`,
+		now(), now(), id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrNotFound
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
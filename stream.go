@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamHistory is how many recent events the hub keeps for clients
+	// resuming with Last-Event-ID.
+	streamHistory = 1024
+	// streamBuffer is how far a subscriber may fall behind before it is
+	// disconnected; it can then resume from its last event.
+	streamBuffer      = 64
+	streamHeartbeat   = 25 * time.Second
+	streamRetryMillis = 3000
+)
+
+// Event types pushed to /stream subscribers.
+const (
+	eventCommentCreated = "comment.created"
+	eventCommentEdited  = "comment.edited"
+	eventCommentDeleted = "comment.deleted"
+	eventCommentPurged  = "comment.purged"
+	eventCommentVotes   = "comment.votes"
+	// eventReset tells a resuming client that events were missed, e.g.
+	// across a restart, and it should reload the page's comments.
+	eventReset = "reset"
+)
+
+type hubEvent struct {
+	seq  uint64
+	url  string
+	typ  string
+	data []byte
+}
+
+// eventHub fans out comment activity to the /stream subscribers of each
+// page. Event IDs are "<epoch>-<seq>"; the epoch changes on every start so
+// IDs from a previous process are recognized as stale.
+type eventHub struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	recent []hubEvent
+	subs   map[string]map[chan hubEvent]struct{}
+}
+
+var hub = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:  make(map[string]map[chan hubEvent]struct{}),
+	}
+}
+
+func (h *eventHub) eventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// publish sends v as JSON to every subscriber of url. Subscribers that are
+// too far behind are dropped rather than blocking the publisher.
+func (h *eventHub) publish(url, typ string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error encoding stream event:", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := hubEvent{seq: h.seq, url: url, typ: typ, data: data}
+	if len(h.recent) == streamHistory {
+		h.recent = append(h.recent[:0], h.recent[1:]...)
+	}
+	h.recent = append(h.recent, e)
+
+	for ch := range h.subs[url] {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs[url], ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber for url. If lastID is set, it also
+// returns the events on url published after it, or reset if some of them are
+// no longer known.
+func (h *eventHub) subscribe(url, lastID string) (ch chan hubEvent, replay []hubEvent, reset bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastID != "" {
+		epoch, seqStr, _ := strings.Cut(lastID, "-")
+		last, err := strconv.ParseUint(seqStr, 10, 64)
+		switch {
+		case err != nil || epoch != h.epoch || last > h.seq:
+			reset = true
+		case len(h.recent) > 0 && last+1 < h.recent[0].seq:
+			reset = true
+		default:
+			for _, e := range h.recent {
+				if e.seq > last && e.url == url {
+					replay = append(replay, e)
+				}
+			}
+		}
+	}
+
+	ch = make(chan hubEvent, streamBuffer)
+	if h.subs[url] == nil {
+		h.subs[url] = make(map[chan hubEvent]struct{})
+	}
+	h.subs[url][ch] = struct{}{}
+	return ch, replay, reset
+}
+
+func (h *eventHub) unsubscribe(url string, ch chan hubEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[url][ch]; ok {
+		delete(h.subs[url], ch)
+		close(ch)
+	}
+	if len(h.subs[url]) == 0 {
+		delete(h.subs, url)
+	}
+}
+
+// publishComment loads a comment as an anonymous viewer sees it and
+// publishes it to its page's subscribers and webhooks.
+func publishComment(ctx context.Context, typ string, id int) {
+	c, err := repo.Comments.Get(ctx, id, 0)
+	if err != nil {
+		log.Println("Error loading comment for stream:", err)
+		return
+	}
+	if typ == eventCommentVotes {
+		counts := map[string]any{
+			"id":            c.ID,
+			"like_count":    c.LikeCount,
+			"dislike_count": c.DislikeCount,
+			"reactions":     c.Reactions,
+		}
+		hub.publish(c.URL, typ, counts)
+		fireWebhooks(ctx, webhookLikeChanged, c.URL, counts)
+		return
+	}
+	hub.publish(c.URL, typ, c)
+
+	event := typ
+	if typ == eventCommentCreated && c.ParentID != nil {
+		event = webhookReplyCreated
+	}
+	fireWebhooks(ctx, event, c.URL, c)
+}
+
+// streamComments serves GET /stream?url= as Server-Sent Events. Each event's
+// data is the comment as /comments returns it, except comment.votes, which
+// carries only the new counts, and comment.purged, which carries only the
+// ID. Clients resume with the standard Last-Event-ID header, or the
+// last_event_id parameter when they cannot set headers.
+func streamComments(w http.ResponseWriter, r *http.Request) {
+	pageURL, ok := requestPageURL(w, r)
+	if !ok {
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	rc := http.NewResponseController(w)
+	ch, replay, reset := hub.subscribe(pageURL, lastID)
+	defer hub.unsubscribe(pageURL, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis)
+	if reset {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventReset)
+	}
+	for _, e := range replay {
+		writeStreamEvent(w, e)
+	}
+	if err := rc.Flush(); err != nil {
+		log.Println("Streaming not supported:", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeStreamEvent(w, e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, e hubEvent) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", hub.eventID(e.seq), e.typ, e.data)
+}
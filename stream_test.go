@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch chan hubEvent) hubEvent {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return hubEvent{}
+	}
+}
+
+func TestEventHubSubscribe(t *testing.T) {
+	h := newEventHub()
+	a, _, _ := h.subscribe("https://a.example/", "")
+	b, _, _ := h.subscribe("https://b.example/", "")
+
+	h.publish("https://a.example/", eventCommentCreated, map[string]int{"id": 1})
+	if e := receive(t, a); e.typ != eventCommentCreated || string(e.data) != `{"id":1}` || e.seq != 1 {
+		t.Errorf("received %+v", e)
+	}
+	select {
+	case e := <-b:
+		t.Errorf("subscriber of another page received %+v", e)
+	default:
+	}
+
+	h.unsubscribe("https://a.example/", a)
+	if _, ok := <-a; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+	if _, ok := h.subs["https://a.example/"]; ok {
+		t.Error("page without subscribers is still tracked")
+	}
+}
+
+func TestEventHubReplay(t *testing.T) {
+	h := newEventHub()
+	const page = "https://a.example/"
+	for _, u := range []string{page, page, "https://b.example/", page} {
+		h.publish(u, eventCommentCreated, nil)
+	}
+
+	tests := []struct {
+		name       string
+		lastID     string
+		wantReplay []uint64
+		wantReset  bool
+	}{
+		{"fresh subscriber", "", nil, false},
+		{"resume", h.eventID(1), []uint64{2, 4}, false},
+		{"up to date", h.eventID(4), nil, false},
+		{"other process", "0-1", nil, true},
+		{"future event", h.eventID(5), nil, true},
+		{"malformed", "nonsense", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, replay, reset := h.subscribe(page, tt.lastID)
+			defer h.unsubscribe(page, ch)
+			var seqs []uint64
+			for _, e := range replay {
+				seqs = append(seqs, e.seq)
+			}
+			if reset != tt.wantReset || len(seqs) != len(tt.wantReplay) {
+				t.Fatalf("replay %v, reset %v; want %v, %v", seqs, reset, tt.wantReplay, tt.wantReset)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantReplay[i] {
+					t.Errorf("replay %v, want %v", seqs, tt.wantReplay)
+				}
+			}
+		})
+	}
+
+	// Once the first events fall out of the history, resuming from them
+	// needs a reset.
+	for range streamHistory {
+		h.publish(page, eventCommentCreated, nil)
+	}
+	ch, replay, reset := h.subscribe(page, h.eventID(1))
+	defer h.unsubscribe(page, ch)
+	if !reset || len(replay) != 0 {
+		t.Errorf("resuming from a forgotten event: %d replayed, reset %v; want a reset", len(replay), reset)
+	}
+	if _, replay, reset := h.subscribe(page, h.eventID(h.seq-1)); reset || len(replay) != 1 {
+		t.Errorf("resuming from a recent event: %d replayed, reset %v", len(replay), reset)
+	}
+}
+
+func TestEventHubDropsSlowSubscriber(t *testing.T) {
+	h := newEventHub()
+	const page = "https://a.example/"
+	slow, _, _ := h.subscribe(page, "")
+	fast, _, _ := h.subscribe(page, "")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range streamBuffer + 1 {
+			h.publish(page, eventCommentVotes, nil)
+			receive(t, fast)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	for range streamBuffer {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow subscriber was not disconnected")
+	}
+	if _, ok := h.subs[page][fast]; !ok {
+		t.Error("subscriber that kept up was disconnected")
+	}
+	// Handlers unsubscribe when they return; that must not close twice.
+	h.unsubscribe(page, slow)
+}
+
+func TestStreamComments(t *testing.T) {
+	setupTestRepo(t)
+	hub = newEventHub()
+	const page = "https://example.com/"
+	hub.publish(page, eventCommentCreated, map[string]int{"id": 1})
+	hub.publish(page, eventCommentCreated, map[string]int{"id": 2})
+
+	srv := httptest.NewServer(http.HandlerFunc(streamComments))
+	defer srv.Close()
+	req, err := http.NewRequest("GET", srv.URL+"/stream?url="+url.QueryEscape(page), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", hub.eventID(1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event []string
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				event = append(event, line)
+				continue
+			}
+			events <- strings.Join(event, "\n")
+			event = nil
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return ""
+		}
+	}
+
+	if e := next(); e != fmt.Sprintf("retry: %d", streamRetryMillis) {
+		t.Errorf("first event = %q, want the retry interval", e)
+	}
+	want := "id: " + hub.eventID(2) + "\nevent: comment.created\ndata: {\"id\":2}"
+	if e := next(); e != want {
+		t.Errorf("replayed event = %q, want %q", e, want)
+	}
+
+	hub.publish(page, eventCommentDeleted, map[string]int{"id": 2})
+	want = "id: " + hub.eventID(3) + "\nevent: comment.deleted\ndata: {\"id\":2}"
+	if e := next(); e != want {
+		t.Errorf("live event = %q, want %q", e, want)
+	}
+}
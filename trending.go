@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"myproject/store"
+)
+
+const (
+	defaultTrendingLimit = 20
+	maxTrendingLimit     = 100
+)
+
+// getTrending ranks pages by recent comment and like activity. window is 1h,
+// 24h (the default) or 7d: activity older than the window counts for half as
+// much, and for a quarter at twice its age.
+func getTrending(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	window := query.Get("window")
+	if window == "" {
+		window = "24h"
+	}
+	if _, ok := store.TrendWindows[window]; !ok {
+		http.Error(w, "window must be one of 1h, 24h or 7d", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTrendingLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTrendingLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTrendingLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pages, err := repo.Trending.Top(r.Context(), window, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, pages)
+}
@@ -0,0 +1,173 @@
+// Package urlcanon maps the many spellings of a web page's address to one
+// canonical URL, so every spelling shares a single comment thread.
+package urlcanon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultStripParams are tracking parameters that never change which page is
+// shown.
+var DefaultStripParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid",
+	"mc_cid", "mc_eid", "igshid", "_ga", "_gl", "ref_src",
+}
+
+// Rules configures a Canonicalizer.
+type Rules struct {
+	// StripParams are query parameters removed from every URL. A trailing
+	// "*" matches any suffix, so "utm_*" removes utm_source and utm_medium.
+	StripParams []string
+	// StripWWW treats www.example.com as example.com.
+	StripWWW bool
+}
+
+// Canonicalizer rewrites URLs according to a set of Rules. It is safe for
+// concurrent use.
+type Canonicalizer struct {
+	exact    map[string]bool
+	prefixes []string
+	stripWWW bool
+}
+
+// ErrUnsupported is returned for URLs that are not http(s) web pages.
+var ErrUnsupported = errors.New("urlcanon: only http and https URLs are supported")
+
+func New(rules Rules) *Canonicalizer {
+	c := &Canonicalizer{exact: make(map[string]bool), stripWWW: rules.StripWWW}
+	for _, p := range rules.StripParams {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			c.prefixes = append(c.prefixes, prefix)
+		} else {
+			c.exact[p] = true
+		}
+	}
+	return c
+}
+
+// Canonicalize returns the canonical form of raw:
+//
+//   - a missing scheme and http are both treated as https; any other scheme
+//     is rejected with ErrUnsupported
+//   - the host is lowercased, converted to its ASCII (punycode) form and
+//     stripped of a trailing dot, the default port of its scheme and,
+//     optionally, "www."
+//   - user info and the fragment are dropped
+//   - trailing slashes are removed from the path, leaving "/" for the root
+//   - tracking parameters are removed and the rest sorted by name
+//
+// Canonicalize is idempotent.
+func (c *Canonicalizer) Canonicalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("urlcanon: empty URL")
+	}
+	if !hasScheme(raw) {
+		raw = "https://" + strings.TrimPrefix(raw, "//")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("urlcanon: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	default:
+		return "", ErrUnsupported
+	}
+
+	host, err := c.host(u)
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	if path == "" {
+		path = "/"
+	}
+
+	canonical := "https://" + host + path
+	if query := c.query(u.Query()); query != "" {
+		canonical += "?" + query
+	}
+	return canonical, nil
+}
+
+// hasScheme reports whether raw starts with a URL scheme such as "https:" or
+// "mailto:". A colon followed by a digit is a port, as in "example.com:8080",
+// not a scheme.
+func hasScheme(raw string) bool {
+	scheme, rest, ok := strings.Cut(raw, ":")
+	if !ok || scheme == "" || !isAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		if b := scheme[i]; !isAlpha(b) && !('0' <= b && b <= '9') && b != '+' && b != '-' && b != '.' {
+			return false
+		}
+	}
+	return rest == "" || rest[0] < '0' || rest[0] > '9'
+}
+
+func isAlpha(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+func (c *Canonicalizer) host(u *url.URL) (string, error) {
+	hostname := strings.TrimSuffix(u.Hostname(), ".")
+	if hostname == "" {
+		return "", errors.New("urlcanon: URL has no host")
+	}
+
+	if net.ParseIP(hostname) == nil {
+		ascii, err := idna.Lookup.ToASCII(hostname)
+		if err != nil {
+			return "", fmt.Errorf("urlcanon: host %q: %w", hostname, err)
+		}
+		hostname = ascii
+		if c.stripWWW {
+			if rest, ok := strings.CutPrefix(hostname, "www."); ok && strings.Contains(rest, ".") {
+				hostname = rest
+			}
+		}
+	}
+
+	// Port 80 only means the default for http; 443 is implied by the https
+	// of the canonical URL whatever the scheme was.
+	port := u.Port()
+	if port == "" || port == "443" || port == "80" && strings.EqualFold(u.Scheme, "http") {
+		if strings.Contains(hostname, ":") {
+			return "[" + hostname + "]", nil
+		}
+		return hostname, nil
+	}
+	return net.JoinHostPort(hostname, port), nil
+}
+
+func (c *Canonicalizer) query(values url.Values) string {
+	for name := range values {
+		if c.stripped(strings.ToLower(name)) {
+			delete(values, name)
+		}
+	}
+	return values.Encode()
+}
+
+func (c *Canonicalizer) stripped(name string) bool {
+	if c.exact[name] {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+	"myproject/urlcanon"
+)
+
+// canon canonicalizes every page URL before it reaches the store.
+var canon *urlcanon.Canonicalizer
+
+// setupURLs builds canon from the config. It runs before migrations so that
+// the migration canonicalizing stored URLs uses the same rules.
+func setupURLs() {
+	canon = urlcanon.New(urlcanon.Rules{
+		StripParams: cfg.URLs.StripParams,
+		StripWWW:    cfg.URLs.StripWWW,
+	})
+	store.CanonicalizeURL = canon.Canonicalize
+}
+
+// requestPageURL reads the canonical form of the "url" query parameter.
+func requestPageURL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	decoded, err := url.QueryUnescape(r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, "Error decoding URL", http.StatusBadRequest)
+		return "", false
+	}
+	canonical, err := canon.Canonicalize(decoded)
+	if err != nil {
+		http.Error(w, "Invalid page URL: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return canonical, true
+}
+
+// scopeFilter widens a canonical page URL to the pages selected by scope:
+// "page" (the default) is the URL alone, "path-prefix" every page at or below
+// its path and "domain" every page on its host.
+func scopeFilter(pageURL, scope string) (store.PageFilter, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return store.PageFilter{}, err
+	}
+	switch scope {
+	case "", "page":
+		return store.PageFilter{URL: pageURL}, nil
+	case "path-prefix":
+		return store.PageFilter{URL: u.Scheme + "://" + u.Host + strings.TrimRight(u.EscapedPath(), "/"), Prefix: true}, nil
+	case "domain":
+		return store.PageFilter{URL: u.Scheme + "://" + u.Host, Prefix: true}, nil
+	}
+	return store.PageFilter{}, errors.New("scope must be one of page, path-prefix or domain")
+}
+
+// sitePageLimit caps the per-page counts returned by getSiteComments.
+const sitePageLimit = 50
+
+// getSiteComments aggregates the discussion across a site, or the part of it
+// under the optional path parameter: how many pages and comments it has, the
+// most discussed pages, and one page of top-level comments from all of them.
+// The comments are paginated like /comments.
+func getSiteComments(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	siteURL, err := canon.Canonicalize(mux.Vars(r)["domain"] + path)
+	if err != nil {
+		http.Error(w, "Invalid site: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := scopeFilter(siteURL, "path-prefix")
+	if err != nil {
+		http.Error(w, "Invalid site: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := repo.Comments.SiteStats(r.Context(), filter, sitePageLimit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	comments, next, err := repo.Comments.ListTopLevel(r.Context(), filter, viewerID(r), page)
+	switch {
+	case errors.Is(err, store.ErrInvalidCursor):
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if comments == nil {
+		comments = []store.Comment{}
+	}
+
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	writeJSON(w, struct {
+		Prefix string `json:"prefix"`
+		store.SiteStats
+		Comments []store.Comment `json:"comments"`
+	}{filter.URL, stats, comments})
+}
+
+// canonicalizeCommentURL replaces the URL of a comment being posted with its
+// canonical form.
+func canonicalizeCommentURL(w http.ResponseWriter, c *store.Comment) bool {
+	canonical, err := canon.Canonicalize(c.URL)
+	if err != nil {
+		http.Error(w, "Invalid page URL: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	c.URL = canonical
+	return true
+}
+
+// runCanonicalizeURLs implements the "canonicalize-urls" subcommand, which
+// rewrites stored URLs after the canonicalization rules in the config change
+// and returns the process exit code. Upgrading runs the same rewrite once as
+// a migration.
+func runCanonicalizeURLs(args []string) int {
+	flags := flag.NewFlagSet("canonicalize-urls", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the rewrites without changing the database")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	ctx := context.Background()
+
+	pending, err := store.Pending(ctx, db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "database has %d pending migrations; run `migrate up`\n", len(pending))
+		return 1
+	}
+	setupURLs()
+	comments := store.NewSQLite(db).Comments
+
+	urls, err := comments.URLs(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var rewritten, skipped int
+	for _, raw := range urls {
+		canonical, err := canon.Canonicalize(raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %q: %v\n", raw, err)
+			skipped++
+			continue
+		}
+		if canonical == raw {
+			continue
+		}
+
+		rewritten++
+		if *dryRun {
+			fmt.Printf("%s -> %s\n", raw, canonical)
+			continue
+		}
+		n, err := comments.RewriteURL(ctx, raw, canonical)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s -> %s (%d comments)\n", raw, canonical, n)
+	}
+	fmt.Printf("%d URLs rewritten, %d skipped\n", rewritten, skipped)
+	return 0
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"myproject/store"
+)
+
+// Events delivered to webhooks. The comment events carry the comment as
+// /comments returns it to an anonymous viewer.
+const (
+	webhookCommentCreated = "comment.created"
+	webhookCommentEdited  = "comment.edited"
+	webhookCommentDeleted = "comment.deleted"
+	webhookReplyCreated   = "reply.created"
+	webhookLikeChanged    = "like.changed"
+	webhookSummaryUpdated = "summary.updated"
+)
+
+var webhookEvents = []string{
+	webhookCommentCreated,
+	webhookCommentEdited,
+	webhookCommentDeleted,
+	webhookReplyCreated,
+	webhookLikeChanged,
+	webhookSummaryUpdated,
+}
+
+const (
+	// webhookPollInterval is how often the worker looks for retries that
+	// have become due.
+	webhookPollInterval = time.Second
+	webhookBatchSize    = 20
+	// webhookMaxRetryDelay caps the exponential backoff.
+	webhookMaxRetryDelay = 6 * time.Hour
+	// webhookErrorLimit truncates response bodies kept in the delivery log.
+	webhookErrorLimit = 512
+	webhookLogLimit   = 50
+)
+
+var (
+	webhookClient *http.Client
+	// webhookWake nudges the worker when a delivery is queued.
+	webhookWake = make(chan struct{}, 1)
+)
+
+func setupWebhooks() {
+	webhookClient = &http.Client{
+		Timeout: cfg.Webhooks.Timeout,
+		// A redirect would resend the signed payload somewhere the admin
+		// did not register.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	go deliverWebhooks()
+}
+
+// fireWebhooks queues event for every webhook subscribed to it on pageURL.
+// Payloads are built once so redeliveries repeat exactly what was sent.
+func fireWebhooks(ctx context.Context, event, pageURL string, data any) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		log.Println("Error parsing webhook page URL:", err)
+		return
+	}
+	hooks, err := repo.Webhooks.Matching(ctx, event, pageURL, u.Host)
+	if err != nil {
+		log.Println("Error finding webhooks:", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"event":       event,
+		"url":         pageURL,
+		"occurred_at": time.Now().UTC().Format(time.RFC3339),
+		"data":        data,
+	})
+	if err != nil {
+		log.Println("Error encoding webhook payload:", err)
+		return
+	}
+	for _, h := range hooks {
+		if err := repo.Webhooks.Enqueue(ctx, h.ID, event, payload); err != nil {
+			log.Println("Error queueing webhook delivery:", err)
+		}
+	}
+	wakeWebhooks()
+}
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverWebhooks sends due deliveries until the process exits. Deliveries
+// survive restarts in the database, so nothing is lost if it stops midway.
+func deliverWebhooks() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+
+		ctx := context.Background()
+		due, err := repo.Webhooks.Due(ctx, webhookBatchSize)
+		if err != nil {
+			log.Println("Error loading webhook deliveries:", err)
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, d := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				attemptDelivery(ctx, d)
+			}()
+		}
+		wg.Wait()
+		if len(due) == webhookBatchSize {
+			wakeWebhooks()
+		}
+	}
+}
+
+// attemptDelivery posts one delivery and records the outcome. Any 2xx
+// response counts as delivered; everything else is retried with exponential
+// backoff until cfg.Webhooks.MaxAttempts is reached.
+func attemptDelivery(ctx context.Context, d store.Delivery) {
+	statusCode, errMsg := postWebhook(ctx, d)
+	if errMsg == "" {
+		if err := repo.Webhooks.Succeeded(ctx, d.ID, statusCode); err != nil {
+			log.Println("Error recording webhook delivery:", err)
+		}
+		return
+	}
+
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+	var retryAt *time.Time
+	if d.Attempts+1 < cfg.Webhooks.MaxAttempts {
+		t := time.Now().Add(retryDelay(cfg.Webhooks.RetryDelay, d.Attempts))
+		retryAt = &t
+	} else {
+		log.Printf("Webhook delivery %d to %s failed after %d attempts: %s", d.ID, d.TargetURL, d.Attempts+1, errMsg)
+	}
+	if err := repo.Webhooks.Retry(ctx, d.ID, code, errMsg, retryAt); err != nil {
+		log.Println("Error recording webhook delivery:", err)
+	}
+}
+
+// retryDelay is how long to wait after the failed attempt number attempts,
+// counting from 0: base doubled per earlier attempt, capped at
+// webhookMaxRetryDelay. Shifting only while the result stays under the cap
+// keeps a high max_attempts from overflowing the delay.
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	shift := min(attempts, 20)
+	if base > 0 && base <= webhookMaxRetryDelay>>shift {
+		return base << shift
+	}
+	return webhookMaxRetryDelay
+}
+
+// postWebhook sends d and returns the response status, plus a description
+// of the failure if it did not succeed.
+func postWebhook(ctx context.Context, d store.Delivery) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TargetURL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "urlext-webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, ""
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorLimit))
+	return resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+// signWebhook is the hex HMAC-SHA256 of "<timestamp>.<payload>". Receivers
+// recompute it with their secret and should reject stale timestamps to
+// prevent replays.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookIDVar parses the {id} route variable.
+func webhookIDVar(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// createWebhook registers a webhook. Scope "page" takes a page URL and
+// "domain" a host, both canonicalized like comment URLs; "global" takes
+// nothing. The signing secret is only returned here.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TargetURL string   `json:"target_url"`
+		Scope     string   `json:"scope"`
+		Match     string   `json:"match"`
+		Events    []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if u, err := url.Parse(req.TargetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "target_url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEvents
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			http.Error(w, fmt.Sprintf("Unknown event %q", e), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events := slices.Clone(req.Events)
+	slices.Sort(events)
+	hook := store.Webhook{TargetURL: req.TargetURL, Scope: req.Scope, Events: slices.Compact(events)}
+	switch req.Scope {
+	case store.WebhookGlobal:
+		if req.Match != "" {
+			http.Error(w, "Global webhooks take no match", http.StatusBadRequest)
+			return
+		}
+	case store.WebhookPage, store.WebhookDomain:
+		canonical, err := canon.Canonicalize(req.Match)
+		if err != nil {
+			http.Error(w, "Invalid match: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook.Match = canonical
+		if req.Scope == store.WebhookDomain {
+			u, _ := url.Parse(canonical)
+			hook.Match = u.Host
+		}
+	default:
+		http.Error(w, "scope must be one of global, domain or page", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	hook.Secret = secret
+	if err := repo.Webhooks.Create(r.Context(), &hook); err != nil {
+		http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, hook)
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := repo.Webhooks.List(r.Context())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if hooks == nil {
+		hooks = []store.Webhook{}
+	}
+	writeJSON(w, hooks)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookIDVar(w, r)
+	if !ok {
+		return
+	}
+	err := repo.Webhooks.Delete(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries returns a webhook's most recent deliveries, newest
+// first.
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookIDVar(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := repo.Webhooks.Deliveries(r.Context(), id, webhookLogLimit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	writeJSON(w, deliveries)
+}
+
+// redeliverWebhook queues the payload of an earlier delivery again, e.g.
+// after a failed one was fixed on the receiving end.
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookIDVar(w, r)
+	if !ok {
+		return
+	}
+	newID, err := repo.Webhooks.Redeliver(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	wakeWebhooks()
+	writeJSON(w, map[string]int{"delivery_id": newID})
+}
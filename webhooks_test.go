@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"myproject/store"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		base     time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{30 * time.Second, 0, 30 * time.Second},
+		{30 * time.Second, 1, time.Minute},
+		{30 * time.Second, 5, 16 * time.Minute},
+		{30 * time.Second, 9, 4*time.Hour + 16*time.Minute},
+		{30 * time.Second, 10, webhookMaxRetryDelay},
+		{30 * time.Second, 63, webhookMaxRetryDelay},
+		{30 * time.Second, 64, webhookMaxRetryDelay},
+		{30 * time.Second, 1000, webhookMaxRetryDelay},
+		{time.Nanosecond, 20, 1 << 20 * time.Nanosecond},
+		{time.Nanosecond, 1000, 1 << 20 * time.Nanosecond},
+		{webhookMaxRetryDelay, 0, webhookMaxRetryDelay},
+		{24 * time.Hour, 0, webhookMaxRetryDelay},
+		{0, 3, webhookMaxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.base, tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%v, %d) = %v, want %v", tt.base, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// setupTestWebhook registers a global webhook posting to a test receiver
+// that answers each request with the next of statuses, and then 200.
+func setupTestWebhook(t *testing.T, statuses ...int) (store.Webhook, *[]*http.Request) {
+	t.Helper()
+	setupTestRepo(t)
+	// Failed attempts are due again at once.
+	cfg.Webhooks.RetryDelay = time.Nanosecond
+	cfg.Webhooks.MaxAttempts = 3
+
+	var mu sync.Mutex
+	var received []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		if len(received) <= len(statuses) {
+			http.Error(w, "try later", statuses[len(received)-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	webhookClient = srv.Client()
+
+	hook := store.Webhook{TargetURL: srv.URL, Scope: store.WebhookGlobal, Events: webhookEvents, Secret: "s3cret"}
+	if err := repo.Webhooks.Create(context.Background(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook, &received
+}
+
+// deliverDue attempts due deliveries the way deliverWebhooks does, until
+// none are left, and returns the number of attempts.
+func deliverDue(t *testing.T) int {
+	t.Helper()
+	ctx := context.Background()
+	attempts := 0
+	for ; attempts < 10; attempts++ {
+		due, err := repo.Webhooks.Due(ctx, webhookBatchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) == 0 {
+			return attempts
+		}
+		for _, d := range due {
+			attemptDelivery(ctx, d)
+		}
+	}
+	t.Fatal("deliveries are still due after 10 attempts")
+	return attempts
+}
+
+func lastDelivery(t *testing.T, hook store.Webhook) store.Delivery {
+	t.Helper()
+	deliveries, err := repo.Webhooks.Deliveries(context.Background(), hook.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	hook, received := setupTestWebhook(t, http.StatusServiceUnavailable)
+	fireWebhooks(context.Background(), webhookCommentCreated, "https://example.com/", map[string]int{"id": 7})
+
+	if n := deliverDue(t); n != 2 {
+		t.Fatalf("%d attempts, want a retry after the 503", n)
+	}
+	d := lastDelivery(t, hook)
+	if d.Status != store.DeliverySucceeded || d.Attempts != 2 || *d.LastStatusCode != http.StatusOK || d.LastError != "" {
+		t.Errorf("delivery = %+v, want succeeded on the second attempt", d)
+	}
+
+	for i, r := range *received {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			Event string         `json:"event"`
+			URL   string         `json:"url"`
+			Data  map[string]int `json:"data"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Event != webhookCommentCreated || payload.URL != "https://example.com/" || payload.Data["id"] != 7 {
+			t.Errorf("attempt %d: payload = %s", i, body)
+		}
+		if r.Header.Get("X-Webhook-Event") != webhookCommentCreated || r.Header.Get("X-Webhook-Delivery") != strconv.Itoa(d.ID) {
+			t.Errorf("attempt %d: headers = %v", i, r.Header)
+		}
+
+		// Verify the signature the way a receiver would.
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write([]byte(r.Header.Get("X-Webhook-Timestamp") + "."))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if got := r.Header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+			t.Errorf("attempt %d: signature = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	hook, received := setupTestWebhook(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	fireWebhooks(context.Background(), webhookCommentDeleted, "https://example.com/", nil)
+
+	if n := deliverDue(t); n != cfg.Webhooks.MaxAttempts || len(*received) != n {
+		t.Fatalf("%d attempts and %d requests, want %d", n, len(*received), cfg.Webhooks.MaxAttempts)
+	}
+	d := lastDelivery(t, hook)
+	if d.Status != store.DeliveryFailed || d.Attempts != cfg.Webhooks.MaxAttempts || d.NextAttemptAt != nil ||
+		*d.LastStatusCode != http.StatusBadGateway || d.LastError != "502 Bad Gateway: try later" {
+		t.Errorf("delivery = %+v, want it failed for good", d)
+	}
+}